@@ -0,0 +1,132 @@
+package threadpool
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchingPool collects submitted items into batches and runs a function with each batch on
+// a Pool, once the batch is full or has waited long enough. This suits work that is cheaper
+// done in bulk, such as database inserts.
+type BatchingPool[T any] struct {
+	pool   Pool
+	f      func([]T)
+	cfg    batchConfig
+	mux    sync.Mutex
+	items  []T
+	gen    int // counts batches so a timer firing late does not flush the next batch
+	timer  *time.Timer
+	closed bool
+}
+
+// BatchOption configures a BatchingPool.
+type BatchOption func(*batchConfig)
+
+type batchConfig struct {
+	size     int
+	interval time.Duration
+}
+
+// WithBatchSize sets the most items in a batch, a full batch is ran at once. If n is <=0 it
+// will assume 100, which is also the default.
+func WithBatchSize(n int) BatchOption {
+	return func(c *batchConfig) {
+		c.size = n
+	}
+}
+
+// WithBatchInterval sets the longest a batch waits for more items after its first item is
+// submitted before it is ran. If d is <=0 batches only run once full or on Close(), which is
+// the default.
+func WithBatchInterval(d time.Duration) BatchOption {
+	return func(c *batchConfig) {
+		c.interval = d
+	}
+}
+
+// NewBatchingPool creates a BatchingPool running f with each batch as a job on p. Close()
+// must be called once all items are submitted to run the last batch.
+func NewBatchingPool[T any](p Pool, f func([]T), opts ...BatchOption) *BatchingPool[T] {
+	var cfg batchConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.size <= 0 {
+		cfg.size = 100
+	}
+
+	return &BatchingPool[T]{
+		pool: p,
+		f:    f,
+		cfg:  cfg,
+	}
+}
+
+// Submit adds item to the current batch. If that fills the batch it is added to the pool
+// with Add(), blocking until a free thread can work on it. It returns false without adding
+// item once Close() has been called.
+func (b *BatchingPool[T]) Submit(item T) bool {
+	b.mux.Lock()
+	if b.closed {
+		b.mux.Unlock()
+		return false
+	}
+
+	b.items = append(b.items, item)
+	if len(b.items) == 1 && b.cfg.interval > 0 {
+		gen := b.gen
+		b.timer = time.AfterFunc(b.cfg.interval, func() { b.expire(gen) })
+	}
+	var batch []T
+	if len(b.items) >= b.cfg.size {
+		batch = b.take()
+	}
+	b.mux.Unlock()
+
+	b.run(batch)
+	return true
+}
+
+// Close runs the current batch, even if not full, and stops any more items from being
+// submitted. It is safe to call more than once.
+func (b *BatchingPool[T]) Close() {
+	b.mux.Lock()
+	b.closed = true
+	batch := b.take()
+	b.mux.Unlock()
+
+	b.run(batch)
+}
+
+// expire runs the batch gen once its interval has passed, if it has not already ran.
+func (b *BatchingPool[T]) expire(gen int) {
+	b.mux.Lock()
+	var batch []T
+	if gen == b.gen {
+		batch = b.take()
+	}
+	b.mux.Unlock()
+
+	b.run(batch)
+}
+
+// take returns the current batch and starts a new one. It expects b.mux to be held.
+func (b *BatchingPool[T]) take() []T {
+	batch := b.items
+	b.items = nil
+	b.gen++
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+func (b *BatchingPool[T]) run(batch []T) {
+	if len(batch) == 0 {
+		return
+	}
+	b.pool.Add(func() {
+		b.f(batch)
+	})
+}
@@ -0,0 +1,73 @@
+package threadpool
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type batches struct {
+	mux   sync.Mutex
+	sizes []int
+}
+
+func (b *batches) add(batch []int) {
+	b.mux.Lock()
+	b.sizes = append(b.sizes, len(batch))
+	b.mux.Unlock()
+}
+
+func (b *batches) get() []int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	sizes := append([]int(nil), b.sizes...)
+	sort.Ints(sizes)
+	return sizes
+}
+
+func TestBatchingPool_Size(t *testing.T) {
+	h := New(context.Background(), 2)
+	var found batches
+	b := NewBatchingPool(h, found.add, WithBatchSize(3))
+
+	for i := 0; i < 7; i++ {
+		b.Submit(i)
+	}
+	h.Wait()
+	if sizes := found.get(); len(sizes) != 2 || sizes[0] != 3 || sizes[1] != 3 {
+		t.Fatalf("expected %v but found %v", []int{3, 3}, sizes)
+	}
+
+	b.Close()
+	h.Wait()
+	if sizes := found.get(); len(sizes) != 3 || sizes[0] != 1 {
+		t.Fatalf("expected %v but found %v", []int{1, 3, 3}, sizes)
+	}
+	if b.Submit(7) {
+		t.Fatalf("expected Submit to fail after Close")
+	}
+}
+
+func TestBatchingPool_Interval(t *testing.T) {
+	h := New(context.Background(), 2)
+	var found batches
+	b := NewBatchingPool(h, found.add, WithBatchSize(100), WithBatchInterval(20*time.Millisecond))
+	defer b.Close()
+
+	b.Submit(1)
+	b.Submit(2)
+	time.Sleep(60 * time.Millisecond)
+	h.Wait()
+	if sizes := found.get(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Fatalf("expected %v but found %v", []int{2}, sizes)
+	}
+
+	b.Submit(3)
+	time.Sleep(60 * time.Millisecond)
+	h.Wait()
+	if sizes := found.get(); len(sizes) != 2 || sizes[0] != 1 {
+		t.Fatalf("expected %v but found %v", []int{1, 2}, sizes)
+	}
+}
@@ -0,0 +1,74 @@
+package threadpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// completions counts a pool's completed jobs and wakes the goroutines in WaitN() and
+// WaitUntil() as the count grows or a job starts. Completing a job only takes the lock while
+// something is waiting.
+type completions struct {
+	n       int64
+	waiters int32
+	mux     sync.Mutex
+	cond    *sync.Cond
+}
+
+// add counts a completed job and returns the new count.
+func (c *completions) add() int64 {
+	n := atomic.AddInt64(&c.n, 1)
+	if atomic.LoadInt32(&c.waiters) > 0 {
+		c.broadcast()
+	}
+	return n
+}
+
+// changed wakes the goroutines in WaitUntil() to check the stats again, such as when a job
+// starts or gives back its thread.
+func (c *completions) changed() {
+	if atomic.LoadInt32(&c.waiters) > 0 {
+		c.broadcast()
+	}
+}
+
+func (c *completions) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+func (c *completions) broadcast() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.cond != nil {
+		c.cond.Broadcast()
+	}
+}
+
+// wait blocks until the count is at least n or ctx is done.
+func (c *completions) wait(ctx context.Context, n int64) {
+	c.until(ctx, func() bool { return c.count() >= n })
+}
+
+// until blocks until cond returns true, checking it again each time the count grows or
+// changed() is called, and returns true. It returns false if ctx is done first.
+func (c *completions) until(ctx context.Context, cond func() bool) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.cond == nil {
+		c.cond = sync.NewCond(&c.mux)
+	}
+
+	atomic.AddInt32(&c.waiters, 1)
+	defer atomic.AddInt32(&c.waiters, -1)
+	stop := context.AfterFunc(ctx, c.broadcast)
+	defer stop()
+
+	for !cond() {
+		if ctx.Err() != nil {
+			return false
+		}
+		c.cond.Wait()
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package threadpool
+
+import "sync"
+
+// counter counts a pool's outstanding jobs like a sync.WaitGroup, but wakes its waiters by
+// closing a channel once the count reaches zero. Unlike a sync.WaitGroup it is safe to add to
+// from zero while being waited on, and any number of goroutines may wait at once.
+type counter struct {
+	mux  sync.Mutex
+	n    int
+	zero chan struct{} // closed while n is 0, nil until first used
+}
+
+// Add adds delta, which may be negative, to the count. It panics if the count goes negative.
+func (c *counter) Add(delta int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.init()
+	was := c.n
+	c.n += delta
+	switch {
+	case c.n < 0:
+		panic("threadpool: negative job count")
+	case c.n == 0 && was > 0:
+		close(c.zero)
+	case c.n > 0 && was == 0:
+		c.zero = make(chan struct{})
+	}
+}
+
+// Done takes one from the count.
+func (c *counter) Done() {
+	c.Add(-1)
+}
+
+// Wait blocks until the count is zero.
+func (c *counter) Wait() {
+	<-c.done()
+}
+
+// done returns a channel closed once the count is zero.
+func (c *counter) done() <-chan struct{} {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.init()
+	return c.zero
+}
+
+// init makes the zero channel, closed, if it has not been made yet. It expects c.mux to be held.
+func (c *counter) init() {
+	if c.zero == nil {
+		c.zero = make(chan struct{})
+		close(c.zero)
+	}
+}
@@ -0,0 +1,177 @@
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCycle is returned by DAG.Run() when the edges form a cycle.
+var ErrCycle = errors.New("threadpool: dag has a cycle")
+
+// DAG is a set of jobs with dependencies between them. Run() runs each job once all the jobs
+// it depends on have completed, running as many at once as the pool allows.
+type DAG struct {
+	nodes map[string]func() error
+	order []string
+	edges map[string][]string
+}
+
+// NewDAG creates an empty DAG.
+func NewDAG() *DAG {
+	return &DAG{
+		nodes: make(map[string]func() error),
+		edges: make(map[string][]string),
+	}
+}
+
+// AddNode adds the job f with the given id, replacing any job already added with that id.
+func (d *DAG) AddNode(id string, f func() error) {
+	if _, ok := d.nodes[id]; !ok {
+		d.order = append(d.order, id)
+	}
+	d.nodes[id] = f
+}
+
+// AddEdge makes the job to depend on the job from, so to is only ran after from completes
+// without an error. Both jobs must be added with AddNode() before Run() is called.
+func (d *DAG) AddEdge(from, to string) {
+	d.edges[from] = append(d.edges[from], to)
+}
+
+// poolDone is implemented by the pools to tell when they will not run any more jobs.
+type poolDone interface {
+	finished() <-chan struct{}
+}
+
+// Run runs the jobs of the DAG on p and returns once they have all completed. The errors of
+// all the jobs that failed are joined and returned. The jobs depending on a failed job,
+// directly or not, are skipped. A job that panics fails with an error holding the recovered
+// value, and a job p will not take, such as one past totalJobs, fails with the error from
+// AddOrErr(), which Run waits on for a free thread.
+//
+//	Before anything is ran Run checks every edge is between added jobs and returns
+//	ErrCycle if the edges form a cycle. If ctx is done no more jobs are started, and once
+//	the jobs already started complete ctx's error is returned with any job errors. If p is
+//	finished, with ForceFinish() for instance, Run returns p.Err() straight away as jobs
+//	waiting on p will never run.
+func (d *DAG) Run(ctx context.Context, p Pool) error {
+	waiting := make(map[string]int, len(d.nodes))
+	for from, tos := range d.edges {
+		if _, ok := d.nodes[from]; !ok {
+			return fmt.Errorf("threadpool: dag edge from unknown node %q", from)
+		}
+		for _, to := range tos {
+			if _, ok := d.nodes[to]; !ok {
+				return fmt.Errorf("threadpool: dag edge to unknown node %q", to)
+			}
+			waiting[to]++
+		}
+	}
+	if d.cyclic(waiting) {
+		return ErrCycle
+	}
+
+	var finished <-chan struct{}
+	if pd, ok := p.(poolDone); ok {
+		finished = pd.finished()
+	}
+
+	type result struct {
+		id  string
+		err error
+	}
+	// room for every node so late results never block after Run returns
+	results := make(chan result, len(d.nodes))
+	var errs []error
+	running := 0
+	start := func(id string) {
+		f := d.nodes[id]
+		err := addOrErr(p, func() {
+			var err error
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("threadpool: dag node %q panicked: %v", id, r)
+				}
+				results <- result{id, err}
+			}()
+			err = f()
+		})
+		if err != nil {
+			// a finished pool's cause says more than ErrPoolClosed
+			if cause := errOf(p); cause != nil {
+				err = cause
+			}
+			errs = append(errs, fmt.Errorf("threadpool: dag node %q not added: %w", id, err))
+			return
+		}
+		running++
+	}
+
+	for _, id := range d.order {
+		if waiting[id] == 0 {
+			start(id)
+		}
+	}
+
+	stopped := false
+	for running > 0 {
+		var r result
+		select {
+		case r = <-results:
+		case <-ctx.Done():
+			if !stopped {
+				errs = append(errs, ctx.Err())
+				stopped = true
+			}
+			// the jobs already started still report, unless the pool finishes first
+			select {
+			case r = <-results:
+			case <-finished:
+				return errors.Join(append(errs, errOf(p))...)
+			}
+		case <-finished:
+			return errors.Join(append(errs, errOf(p))...)
+		}
+		running--
+
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		for _, to := range d.edges[r.id] {
+			waiting[to]--
+			if waiting[to] == 0 && !stopped {
+				start(to)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cyclic returns true if the edges form a cycle, using Kahn's algorithm on a copy of the
+// number of jobs each job is waiting on.
+func (d *DAG) cyclic(waiting map[string]int) bool {
+	left := make(map[string]int, len(waiting))
+	var ready []string
+	for _, id := range d.order {
+		left[id] = waiting[id]
+		if waiting[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	visited := 0
+	for len(ready) > 0 {
+		id := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		visited++
+		for _, to := range d.edges[id] {
+			left[to]--
+			if left[to] == 0 {
+				ready = append(ready, to)
+			}
+		}
+	}
+	return visited != len(d.nodes)
+}
@@ -0,0 +1,221 @@
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDAG_Run(t *testing.T) {
+	// a -> b -> d, a -> c -> d, d -> e and f on its own
+	var mux sync.Mutex
+	finished := map[string]bool{}
+	var running, peak int32
+	node := func(id string, deps ...string) func() error {
+		return func() error {
+			mux.Lock()
+			for _, dep := range deps {
+				if !finished[dep] {
+					t.Errorf("expected %v to finish before %v", dep, id)
+				}
+			}
+			mux.Unlock()
+
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+
+			mux.Lock()
+			finished[id] = true
+			mux.Unlock()
+			return nil
+		}
+	}
+
+	d := NewDAG()
+	d.AddNode("a", node("a"))
+	d.AddNode("b", node("b", "a"))
+	d.AddNode("c", node("c", "a"))
+	d.AddNode("d", node("d", "b", "c"))
+	d.AddNode("e", node("e", "d"))
+	d.AddNode("f", node("f"))
+	d.AddEdge("a", "b")
+	d.AddEdge("a", "c")
+	d.AddEdge("b", "d")
+	d.AddEdge("c", "d")
+	d.AddEdge("d", "e")
+
+	pool := New(context.Background(), 4)
+	if err := d.Run(context.Background(), pool); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+	if len(finished) != 6 {
+		t.Fatalf("expected %v but found %v", 6, len(finished))
+	}
+	if peak < 2 {
+		t.Fatalf("expected independent nodes to overlap but found peak %v", peak)
+	}
+}
+
+func TestDAG_Cycle(t *testing.T) {
+	var ran int32
+	f := func() error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	d := NewDAG()
+	d.AddNode("a", f)
+	d.AddNode("b", f)
+	d.AddNode("c", f)
+	d.AddEdge("a", "b")
+	d.AddEdge("b", "c")
+	d.AddEdge("c", "b")
+
+	err := d.Run(context.Background(), full(New(context.Background(), 2)))
+	if !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected %v but found %v", ErrCycle, err)
+	}
+	if ran != 0 {
+		t.Fatalf("expected %v but found %v", 0, ran)
+	}
+
+	d.AddEdge("a", "missing")
+	if err := d.Run(context.Background(), full(New(context.Background(), 2))); err == nil {
+		t.Fatalf("expected an error for an unknown node")
+	}
+}
+
+func TestDAG_Errors(t *testing.T) {
+	errB := errors.New("b failed")
+	errC := errors.New("c failed")
+	var dRan int32
+
+	d := NewDAG()
+	d.AddNode("a", func() error { return nil })
+	d.AddNode("b", func() error { return errB })
+	d.AddNode("c", func() error { return errC })
+	d.AddNode("d", func() error {
+		atomic.AddInt32(&dRan, 1)
+		return nil
+	})
+	d.AddEdge("a", "b")
+	d.AddEdge("a", "c")
+	d.AddEdge("b", "d")
+
+	err := d.Run(context.Background(), full(New(context.Background(), 2)))
+	if !errors.Is(err, errB) || !errors.Is(err, errC) {
+		t.Fatalf("expected both errors but found %v", err)
+	}
+	if dRan != 0 {
+		t.Fatalf("expected %v but found %v", 0, dRan)
+	}
+}
+
+func TestDAG_PoolFinished(t *testing.T) {
+	pool := New(context.Background(), 1)
+	block := make(chan struct{})
+	pool.Add(func() { <-block })
+	defer close(block)
+
+	d := NewDAG()
+	d.AddNode("a", func() error { return nil })
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pool.ForceFinish()
+	}()
+	if err := d.Run(context.Background(), pool); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v but found %v", context.Canceled, err)
+	}
+}
+
+func TestDAG_Panic(t *testing.T) {
+	var cRan int32
+	d := NewDAG()
+	d.AddNode("a", func() error { return nil })
+	d.AddNode("b", func() error { panic("boom") })
+	d.AddNode("c", func() error {
+		atomic.AddInt32(&cRan, 1)
+		return nil
+	})
+	d.AddEdge("a", "b")
+	d.AddEdge("b", "c")
+
+	done := make(chan error)
+	go func() {
+		done <- d.Run(context.Background(), full(New(context.Background(), 2)))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected an error for the panicking job")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Run to return after a job panicked")
+	}
+	if cRan != 0 {
+		t.Fatalf("expected %v but found %v", 0, cRan)
+	}
+}
+
+func TestDAG_NotAdded(t *testing.T) {
+	d := NewDAG()
+	d.AddNode("a", func() error { return nil })
+	d.AddNode("b", func() error { return nil })
+	d.AddNode("c", func() error { return nil })
+	d.AddEdge("a", "b")
+	d.AddEdge("b", "c")
+
+	// room for a alone, b is past totalJobs
+	done := make(chan error)
+	go func() {
+		done <- d.Run(context.Background(), full(NewFixedSize(context.Background(), 2, 1)))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPoolClosed) {
+			t.Fatalf("expected %v but found %v", ErrPoolClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Run to return once a job was not added")
+	}
+}
+
+func TestDAG_CancelThenPoolFinished(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := New(context.Background(), 1)
+	block := make(chan struct{})
+	defer close(block)
+
+	d := NewDAG()
+	d.AddNode("a", func() error {
+		<-block
+		return nil
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- d.Run(ctx, pool)
+	}()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	pool.ForceFinish()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v but found %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Run to return once the pool finished after cancelling")
+	}
+}
@@ -0,0 +1,43 @@
+package threadpool
+
+import "sync"
+
+// The reasons given by Dropped() for jobs that were added but not ran.
+const (
+	// DropStale is a job from AddIfFresh() that was no longer needed when it got a thread.
+	DropStale = "stale"
+	// DropMaxDepth is a job nested deeper than WithMaxDepth() allows.
+	DropMaxDepth = "max depth"
+	// DropExpired is a job from AddCtx() whose context was done when it got a thread.
+	DropExpired = "expired"
+	// DropRateLimited is a job whose pool finished while it waited on WithRateLimit().
+	DropRateLimited = "rate limited"
+)
+
+// drops counts the jobs dropped by a pool for each reason.
+type drops struct {
+	mux     sync.Mutex
+	reasons map[string]int64
+}
+
+func (d *drops) add(reason string) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if d.reasons == nil {
+		d.reasons = make(map[string]int64)
+	}
+	d.reasons[reason]++
+}
+
+// counts returns a copy of the number of jobs dropped for each reason.
+func (d *drops) counts() map[string]int64 {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	counts := make(map[string]int64, len(d.reasons))
+	for reason, n := range d.reasons {
+		counts[reason] = n
+	}
+	return counts
+}
@@ -0,0 +1,45 @@
+package threadpool
+
+import (
+	"errors"
+	"sync"
+)
+
+// jobErrors collects the errors returned by a pool's jobs from AddErr().
+type jobErrors struct {
+	mux  sync.Mutex
+	errs []error
+}
+
+// add keeps err if it is not nil and returns the number of errors kept so far.
+func (j *jobErrors) add(err error) int {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if err != nil {
+		j.errs = append(j.errs, err)
+	}
+	return len(j.errs)
+}
+
+// list returns a copy of the errors collected so far.
+func (j *jobErrors) list() []error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if len(j.errs) == 0 {
+		return nil
+	}
+	return append([]error(nil), j.errs...)
+}
+
+// first returns the first n errors joined, or nil if there are none.
+func (j *jobErrors) first(n int) error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if n > len(j.errs) {
+		n = len(j.errs)
+	}
+	return errors.Join(j.errs[:n]...)
+}
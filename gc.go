@@ -0,0 +1,74 @@
+package threadpool
+
+import (
+	"context"
+	"runtime/metrics"
+	"time"
+)
+
+var (
+	// gcCycles returns the number of completed GC cycles, it is replaced in tests.
+	gcCycles = readGCCycles
+
+	// gcBackoffInterval is how often WithGCBackoff() checks the GC rate.
+	gcBackoffInterval = 100 * time.Millisecond
+)
+
+const (
+	// gcBackoffRate is the GC cycles per second above which a thread is held back.
+	gcBackoffRate = 20
+	// gcRestoreRate is the GC cycles per second below which a held back thread is freed.
+	gcRestoreRate = gcBackoffRate / 2
+)
+
+func readGCCycles() uint64 {
+	s := []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+// backoffOnGC holds threads from threads() back while the GC rate read from cycles is high, one more at
+// each check down to a single free thread, and frees them again one at a time once the rate
+// settles, giving them back with giveBack. It returns once ctx is done.
+func backoffOnGC(ctx context.Context, threads func() chan bool, giveBack func(), cycles func() uint64, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	held := 0
+	defer func() {
+		for ; held > 0; held-- {
+			giveBack()
+		}
+	}()
+
+	lastCycles, lastTime := cycles(), time.Now()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		count, now := cycles(), time.Now()
+		rate := float64(count-lastCycles) / now.Sub(lastTime).Seconds()
+		lastCycles, lastTime = count, now
+
+		switch {
+		case rate > gcBackoffRate && held < cap(threads())-1:
+			// the thread is taken as soon as a job gives it back
+			select {
+			case <-threads():
+				held++
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		case rate < gcRestoreRate && held > 0:
+			giveBack()
+			held--
+		}
+	}
+}
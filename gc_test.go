@@ -0,0 +1,77 @@
+package threadpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool_WithGCBackoff(t *testing.T) {
+	var cycles uint64
+	defer func(read func() uint64, interval time.Duration) {
+		gcCycles = read
+		gcBackoffInterval = interval
+	}(gcCycles, gcBackoffInterval)
+	gcCycles = func() uint64 { return atomic.LoadUint64(&cycles) }
+	gcBackoffInterval = 5 * time.Millisecond
+
+	concur := 4
+	h := New(context.Background(), concur, WithGCBackoff())
+	defer h.ForceFinish()
+
+	peak := func() int32 {
+		var running, peak int32
+		for i := 0; i < 2*concur; i++ {
+			h.AddNoWait(func() {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+			})
+		}
+		h.Wait()
+		return peak
+	}
+
+	// simulate a GC cycle every millisecond
+	stop := make(chan bool)
+	pressure := make(chan bool)
+	go func() {
+		defer close(pressure)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				atomic.AddUint64(&cycles, 1)
+			}
+		}
+	}()
+
+	c := h.(*dynamicPool).threads()
+	waitFor := func(free int) {
+		for start := time.Now(); len(c) != free; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("expected %v free threads but found %v", free, len(c))
+			}
+		}
+	}
+
+	waitFor(1)
+	if actual := peak(); actual != 1 {
+		t.Fatalf("expected %v but found %v", 1, actual)
+	}
+
+	close(stop)
+	<-pressure
+	waitFor(concur)
+	if actual := peak(); actual != int32(concur) {
+		t.Fatalf("expected %v but found %v", concur, actual)
+	}
+}
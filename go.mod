@@ -1,3 +1,5 @@
 module github.com/nathanhack/threadpool
 
-go 1.15
+go 1.21
+
+require golang.org/x/time v0.10.0
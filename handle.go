@@ -0,0 +1,42 @@
+package threadpool
+
+import "sync/atomic"
+
+// Job is a handle to a single job added by AddJob(), to wait on that job alone rather than
+// the whole pool.
+type Job struct {
+	started int32
+	done    chan struct{}
+}
+
+func newJob() *Job {
+	return &Job{done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed once the job has returned, or once it will never be
+// ran because it was dropped.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Started returns true once the job has begun running. A job whose channel from Done() is
+// closed but which never started was dropped.
+func (j *Job) Started() bool {
+	return atomic.LoadInt32(&j.started) == 1
+}
+
+// wrap returns f marking j as started when it runs.
+func (j *Job) wrap(f func()) func() {
+	return func() {
+		atomic.StoreInt32(&j.started, 1)
+		f()
+	}
+}
+
+// finish closes the channel from Done(). It does nothing for a nil Job, so the pools can
+// call it whether or not the job has a handle.
+func (j *Job) finish() {
+	if j != nil {
+		close(j.done)
+	}
+}
@@ -0,0 +1,82 @@
+package threadpool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPool_AddJob(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 2)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+	for name, h := range pools {
+		release := make(chan struct{})
+		started := make(chan struct{})
+		first := h.AddJob(func() {
+			close(started)
+			<-release
+		})
+		<-started
+		second := h.AddJob(func() {})
+
+		select {
+		case <-second.Done():
+			t.Fatalf("%v: expected the second job to wait on the first", name)
+		case <-time.After(20 * time.Millisecond):
+		}
+		if !first.Started() || second.Started() {
+			t.Fatalf("%v: expected %v and %v but found %v and %v", name, true, false, first.Started(), second.Started())
+		}
+
+		close(release)
+		select {
+		case <-second.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected the second job to finish", name)
+		}
+		if !second.Started() {
+			t.Fatalf("%v: expected %v but found %v", name, true, second.Started())
+		}
+		h.Wait()
+	}
+}
+
+func TestPool_AddJobDropped(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 2)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+	for name, h := range pools {
+		release := make(chan struct{})
+		started := make(chan struct{})
+		h.AddJob(func() {
+			close(started)
+			<-release
+		})
+		<-started
+		queued := h.AddJob(func() {})
+		h.ForceFinish()
+		close(release)
+
+		select {
+		case <-queued.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected the dropped job to be done", name)
+		}
+		if queued.Started() {
+			t.Fatalf("%v: expected %v but found %v", name, false, queued.Started())
+		}
+
+		late := h.AddJob(func() {})
+		select {
+		case <-late.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected a job added after finishing to be done", name)
+		}
+		if late.Started() {
+			t.Fatalf("%v: expected %v but found %v", name, false, late.Started())
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package threadpool
+
+import "context"
+
+// ctxJob returns a job calling f with a context that is done once either poolCtx or ctx is,
+// with the cause of whichever was first. If ctx is already done when the job starts f is not
+// called and the job is counted by d as DropExpired.
+func ctxJob(poolCtx, ctx context.Context, f func(context.Context), d *drops) func() {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return func() {
+		if ctx.Err() != nil {
+			d.add(DropExpired)
+			return
+		}
+
+		merged, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		stop := context.AfterFunc(poolCtx, func() { cancel(context.Cause(poolCtx)) })
+		defer stop()
+
+		f(merged)
+	}
+}
@@ -0,0 +1,143 @@
+package threadpool
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyPercentiles summarizes a set of latencies.
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// LatencyStats summarizes the run times of the jobs added with one label.
+type LatencyStats struct {
+	Count int64
+	Mean  time.Duration
+	P95   time.Duration
+}
+
+// reservoirSize is the most latencies a sample keeps.
+const reservoirSize = 1024
+
+// reservoir keeps a uniform random sample of the latencies added to it, so percentiles can be
+// estimated without storing every latency. The queue latencies and the run times of each
+// label are sampled separately but share the one source of randomness.
+type reservoir struct {
+	mux    sync.Mutex
+	rand   *rand.Rand // nil uses the math/rand package's source
+	queue  sample
+	labels map[string]*sample
+}
+
+// sample is a set of latencies sampled by a reservoir, along with the count and sum of all
+// the latencies added to it.
+type sample struct {
+	samples []time.Duration
+	seen    int64
+	sum     time.Duration
+}
+
+func (r *reservoir) add(d time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.queue.add(d, r.int63n)
+}
+
+// addLabeled adds the run time d of a job with label.
+func (r *reservoir) addLabeled(label string, d time.Duration) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	s, ok := r.labels[label]
+	if !ok {
+		if r.labels == nil {
+			r.labels = make(map[string]*sample)
+		}
+		s = &sample{}
+		r.labels[label] = s
+	}
+	s.add(d, r.int63n)
+}
+
+// int63n returns a random number in [0,n). It expects r.mux to be held.
+func (r *reservoir) int63n(n int64) int64 {
+	if r.rand != nil {
+		return r.rand.Int63n(n)
+	}
+	return rand.Int63n(n)
+}
+
+func (s *sample) add(d time.Duration, int63n func(int64) int64) {
+	s.seen++
+	s.sum += d
+	if len(s.samples) < reservoirSize {
+		s.samples = append(s.samples, d)
+		return
+	}
+	if i := int63n(s.seen); i < reservoirSize {
+		s.samples[i] = d
+	}
+}
+
+// sorted returns a sorted copy of the sampled latencies.
+func (s *sample) sorted() []time.Duration {
+	sorted := make([]time.Duration, len(s.samples))
+	copy(sorted, s.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// rank returns the nearest-rank percentile p of sorted, which must not be empty.
+func rank(sorted []time.Duration, p float64) time.Duration {
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+// clone returns a copy of cfg for a new pool, with its own source seeded from r's source as
+// a *rand.Rand is not safe to share.
+func (r *reservoir) clone(cfg config) config {
+	if cfg.rand != nil {
+		r.mux.Lock()
+		cfg.rand = rand.New(rand.NewSource(cfg.rand.Int63()))
+		r.mux.Unlock()
+	}
+	return cfg
+}
+
+// percentiles returns the estimated percentiles of all the queue latencies added so far.
+func (r *reservoir) percentiles() LatencyPercentiles {
+	r.mux.Lock()
+	sorted := r.queue.sorted()
+	r.mux.Unlock()
+
+	if len(sorted) == 0 {
+		return LatencyPercentiles{}
+	}
+	return LatencyPercentiles{
+		P50: rank(sorted, 0.50),
+		P90: rank(sorted, 0.90),
+		P99: rank(sorted, 0.99),
+	}
+}
+
+// byLabel returns the stats of the run times added for each label so far.
+func (r *reservoir) byLabel() map[string]LatencyStats {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	stats := make(map[string]LatencyStats, len(r.labels))
+	for label, s := range r.labels {
+		stats[label] = LatencyStats{
+			Count: s.seen,
+			Mean:  s.sum / time.Duration(s.seen),
+			P95:   rank(s.sorted(), 0.95),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,81 @@
+package threadpool
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestReservoir_Percentiles(t *testing.T) {
+	r := reservoir{}
+	if p := r.percentiles(); p != (LatencyPercentiles{}) {
+		t.Fatalf("expected %v but found %v", LatencyPercentiles{}, p)
+	}
+
+	// uniform over 1ms..10s, added in a scrambled order
+	total := 10000
+	for i := 0; i < total; i++ {
+		r.add(time.Duration(1+(i*7919)%total) * time.Millisecond)
+	}
+
+	p := r.percentiles()
+	check := func(name string, actual time.Duration, expected time.Duration) {
+		tolerance := time.Duration(total) * time.Millisecond / 20
+		if actual < expected-tolerance || actual > expected+tolerance {
+			t.Fatalf("%v: expected %v but found %v", name, expected, actual)
+		}
+	}
+	check("p50", p.P50, 5*time.Second)
+	check("p90", p.P90, 9*time.Second)
+	check("p99", p.P99, 9900*time.Millisecond)
+}
+
+func TestPool_WithRandSource(t *testing.T) {
+	pools := []pool{
+		full(New(context.Background(), 1, WithRandSource(rand.New(rand.NewSource(42))))),
+		full(New(context.Background(), 1, WithRandSource(rand.New(rand.NewSource(42))))),
+	}
+
+	var samples [][]time.Duration
+	for _, h := range pools {
+		r := &h.(*dynamicPool).latency
+		for i := 0; i < 10*reservoirSize; i++ {
+			r.add(time.Duration(i))
+		}
+		samples = append(samples, r.queue.samples)
+	}
+
+	for i := range samples[0] {
+		if samples[0][i] != samples[1][i] {
+			t.Fatalf("expected identical samples but found %v and %v at %v", samples[0][i], samples[1][i], i)
+		}
+	}
+}
+
+func TestPool_LatencyByLabel(t *testing.T) {
+	h := full(New(context.Background(), 4))
+	for i := 0; i < 20; i++ {
+		h.AddLabeled("fast", func() { time.Sleep(time.Millisecond) })
+		h.AddLabeled("slow", func() { time.Sleep(20 * time.Millisecond) })
+	}
+	h.Wait()
+
+	stats := h.LatencyByLabel()
+	if len(stats) != 2 {
+		t.Fatalf("expected %v but found %v", 2, len(stats))
+	}
+	fast, slow := stats["fast"], stats["slow"]
+	if fast.Count != 20 || slow.Count != 20 {
+		t.Fatalf("expected %v but found %v and %v", 20, fast.Count, slow.Count)
+	}
+	if fast.Mean < time.Millisecond || slow.Mean < 20*time.Millisecond {
+		t.Fatalf("expected means of at least the sleeps but found %v and %v", fast.Mean, slow.Mean)
+	}
+	if fast.P95 >= slow.Mean || fast.Mean >= slow.Mean {
+		t.Fatalf("expected fast below slow but found %+v and %+v", fast, slow)
+	}
+	if slow.P95 < 20*time.Millisecond {
+		t.Fatalf("expected at least %v but found %v", 20*time.Millisecond, slow.P95)
+	}
+}
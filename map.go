@@ -0,0 +1,50 @@
+package threadpool
+
+import "errors"
+
+// ErrIncomplete is returned by Map() when a job did not complete although the pool is not
+// finished, such as when it panicked.
+var ErrIncomplete = errors.New("threadpool: not every job completed")
+
+// Map calls f on each element of in as a job on p and returns the results in the same order
+// as in, once they are all completed. Each job writes only its own result, so no lock is
+// taken. Map waits on its own jobs with Barrier(), so p may be running other jobs too,
+// unless p has no Barrier() and it falls back to Wait().
+//
+// If not every job completed, the results of the longest run of elements from the start of
+// in that completed are returned along with an error, so the number of results tells how far
+// Map got. The error is p's Err() if p is finished, otherwise the error from AddOrErr() if a
+// job was not added, such as once a fixed size pool has no totalJobs left, and otherwise
+// ErrIncomplete, such as when a job panicked.
+//
+// For jobs that need only the index of their element and return nothing, see AddIndexed().
+func Map[T, R any](p Pool, in []T, f func(T) R) ([]R, error) {
+	results := make([]R, len(in))
+	completed := make([]bool, len(in))
+
+	var addErr error
+	for i := range in {
+		i := i
+		if addErr = addOrErr(p, func() {
+			results[i] = f(in[i])
+			completed[i] = true
+		}); addErr != nil {
+			break
+		}
+	}
+	barrier(p)()
+
+	for i, ok := range completed {
+		if ok {
+			continue
+		}
+		if err := errOf(p); err != nil {
+			return results[:i], err
+		}
+		if addErr != nil {
+			return results[:i], addErr
+		}
+		return results[:i], ErrIncomplete
+	}
+	return results, nil
+}
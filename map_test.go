@@ -0,0 +1,88 @@
+package threadpool
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	in := make([]int, 100)
+	for i := range in {
+		in[i] = i
+	}
+
+	h := New(context.Background(), 4)
+	actual, err := Map(h, in, func(i int) string {
+		time.Sleep(time.Duration(i%3) * time.Millisecond)
+		return strconv.Itoa(i)
+	})
+	if err != nil {
+		t.Fatalf("expected no error but found %v", err)
+	}
+	if len(actual) != len(in) {
+		t.Fatalf("expected %v but found %v", len(in), len(actual))
+	}
+	for i := range in {
+		if actual[i] != strconv.Itoa(i) {
+			t.Fatalf("expected %v but found %v", i, actual[i])
+		}
+	}
+}
+
+func TestMap_Cancel(t *testing.T) {
+	in := make([]int, 100)
+	h := full(NewFixedSize(context.Background(), 1, len(in)))
+	actual, err := Map(h, in, func(i int) int {
+		if h.Stats().Completed == 9 {
+			h.ForceFinish()
+		}
+		return 1
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected %v but found %v", context.Canceled, err)
+	}
+	if len(actual) != 10 {
+		t.Fatalf("expected %v but found %v", 10, len(actual))
+	}
+	for _, v := range actual {
+		if v != 1 {
+			t.Fatalf("expected %v but found %v", 1, v)
+		}
+	}
+}
+
+func TestMap_Incomplete(t *testing.T) {
+	in := make([]int, 10)
+	for i := range in {
+		in[i] = i
+	}
+	square := func(i int) int { return i * i }
+
+	// a panicking job leaves its result missing without finishing the pool
+	h := New(context.Background(), 2, WithPanicHandler(func(any, []byte) {}))
+	actual, err := Map(h, in, func(i int) int {
+		if i == 4 {
+			panic("boom")
+		}
+		return square(i)
+	})
+	if err != ErrIncomplete || len(actual) != 4 {
+		t.Fatalf("expected %v results and %v but found %v and %v", 4, ErrIncomplete, len(actual), err)
+	}
+
+	// a fixed size pool runs out of totalJobs
+	actual, err = Map(NewFixedSize(context.Background(), 2, 6), in, square)
+	if err != ErrPoolClosed || len(actual) != 6 {
+		t.Fatalf("expected %v results and %v but found %v and %v", 6, ErrPoolClosed, len(actual), err)
+	}
+
+	// a draining pool takes no jobs
+	d := full(New(context.Background(), 2))
+	d.Drain()
+	actual, err = Map(d, in, square)
+	if err != ErrPoolClosed || len(actual) != 0 {
+		t.Fatalf("expected %v results and %v but found %v and %v", 0, ErrPoolClosed, len(actual), err)
+	}
+}
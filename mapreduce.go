@@ -0,0 +1,68 @@
+package threadpool
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MapReduceLines reads r line by line, calls mapf on each line using a pool of concurrency
+// threads and combines the results with reduce. Reading waits while all threads are busy, so
+// only a few lines are held in memory at a time.
+//
+//	Results are reduced in the order they complete, so reduce must be associative and
+//	commutative. It is never called concurrently. Lines do not include their line ending,
+//	and a final line without one is still mapped. If r has no lines the zero value of R
+//	is returned.
+//
+// If reading r fails the error is returned along with the result of the lines read so far.
+// The same is done with ctx's error if ctx is done before all lines are read. If concurrency
+// is <=0 it will assume runtime.NumCPU(). A nil ctx is treated as context.Background().
+func MapReduceLines[R any](ctx context.Context, concurrency int, r io.Reader, mapf func(line string) R, reduce func(R, R) R) (R, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var (
+		mux    sync.Mutex
+		result R
+		empty  = true
+	)
+
+	p := New(ctx, concurrency)
+	defer p.ForceFinish()
+	br := bufio.NewReader(r)
+	var err error
+	for err == nil {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		var line string
+		line, err = br.ReadString('\n')
+		if len(line) == 0 {
+			continue
+		}
+
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		p.Add(func() {
+			v := mapf(line)
+
+			mux.Lock()
+			defer mux.Unlock()
+			if empty {
+				result = v
+				empty = false
+				return
+			}
+			result = reduce(result, v)
+		})
+	}
+	p.Wait()
+
+	if err == io.EOF {
+		err = nil
+	}
+	return result, err
+}
@@ -0,0 +1,73 @@
+package threadpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMapReduceLines(t *testing.T) {
+	var lines []string
+	expected := 0
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, strconv.Itoa(i))
+		expected += i * i
+	}
+	// no line ending on the last line and a windows line ending on the first
+	input := strings.Join(lines, "\n")
+	input = strings.Replace(input, "\n", "\r\n", 1)
+
+	square := func(line string) int {
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			t.Errorf("unexpected line %q", line)
+		}
+		return n * n
+	}
+	sum := func(a, b int) int { return a + b }
+
+	actual, err := MapReduceLines(context.Background(), 4, strings.NewReader(input), square, sum)
+	if err != nil {
+		t.Fatalf("expected no error but found %v", err)
+	}
+	if actual != expected {
+		t.Fatalf("expected %v but found %v", expected, actual)
+	}
+
+	actual, err = MapReduceLines(context.Background(), 4, strings.NewReader(""), square, sum)
+	if err != nil || actual != 0 {
+		t.Fatalf("expected %v but found %v, %v", 0, actual, err)
+	}
+
+	// a nil ctx is context.Background()
+	actual, err = MapReduceLines(nil, 4, strings.NewReader("1\n2\n3"), square, sum)
+	if err != nil || actual != 14 {
+		t.Fatalf("expected %v but found %v, %v", 14, actual, err)
+	}
+}
+
+func TestMapReduceLines_ReaderError(t *testing.T) {
+	errRead := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("1\n2\n3"), iotest.ErrReader(errRead))
+
+	count := func(line string) int { return 1 }
+	sum := func(a, b int) int { return a + b }
+
+	actual, err := MapReduceLines(context.Background(), 2, r, count, sum)
+	if err != errRead {
+		t.Fatalf("expected %v but found %v", errRead, err)
+	}
+	if actual != 3 {
+		t.Fatalf("expected %v but found %v", 3, actual)
+	}
+}
+
+func TestMapReduceLines_NoLeak(t *testing.T) {
+	expectNoLeak(t, func(ctx context.Context) {
+		MapReduceLines(ctx, 2, strings.NewReader("a\nb\n"), func(line string) int { return len(line) }, func(a, b int) int { return a + b })
+	})
+}
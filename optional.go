@@ -0,0 +1,170 @@
+package threadpool
+
+import (
+	"context"
+	"time"
+)
+
+// The pools from New() and NewFixedSize() have many more methods than Pool. They are grouped
+// into the interfaces below, which both pools implement, so Pool itself stays small enough
+// for other types to implement. Reach them with a type assertion:
+//
+//	p := threadpool.New(ctx, 4)
+//	stats := p.(threadpool.Inspector).Stats()
+
+// Adder adds jobs in ways other than Add() and AddNoWait().
+type Adder interface {
+	AddOrErr(f func()) error
+	TryAdd(f func()) bool
+	AddBatch(fs []func()) error
+	AddIndexed(i int, f func(int))
+	AddAfter(d time.Duration, f func())
+	AddJob(f func()) *Job
+	Handoff(f func()) bool
+}
+
+// ConditionalAdder adds jobs that are only ran, or ran again, under some condition.
+type ConditionalAdder interface {
+	AddOnce(key string, f func())
+	AddIdempotent(f func())
+	AddIfFresh(stillNeeded func() bool, f func())
+}
+
+// ErrorAdder adds jobs that return an error and collects the errors.
+type ErrorAdder interface {
+	AddErr(f func() error)
+	AddRetry(f func() error, attempts int, backoff func(attempt int) time.Duration)
+	AddRetryIf(attempts int, backoff time.Duration, retryable func(error) bool, f func() error)
+	Errors() []error
+	WaitErr() error
+}
+
+// ContextAdder adds jobs that are given a context.
+type ContextAdder interface {
+	AddCtx(ctx context.Context, f func(context.Context))
+	AddWithValues(ctx context.Context, f func(context.Context))
+}
+
+// Waiter waits on a pool in ways other than Wait().
+type Waiter interface {
+	WaitTimeout(d time.Duration) bool
+	WaitHeartbeat(every time.Duration, beat func(Stats))
+	WaitCtx(ctx context.Context) error
+	WaitN(n int)
+	WaitUntil(pred func(Stats) bool) bool
+	Barrier() func()
+	DrainProgress() <-chan int
+	Then(f func())
+}
+
+// Pauser holds back jobs from starting for a while.
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
+// Finisher finishes a pool and reports why it finished.
+type Finisher interface {
+	ForceFinished() bool
+	ForceFinishN() int
+	Err() error
+	Close() error
+}
+
+// Drainer stops a pool taking jobs while letting the jobs already added complete.
+type Drainer interface {
+	Drain()
+	Draining() bool
+	CommitRemaining() int
+}
+
+// Reconfigurer changes the threads of a pool or makes it ready for another set of jobs.
+type Reconfigurer interface {
+	Resize(n int) error
+	Clone(ctx context.Context) Pool
+	Reopen() error
+	Reset() error
+}
+
+// Reserver takes threads from a pool for more than one job at a time, or for code that is
+// not a job.
+type Reserver interface {
+	Acquire(ctx context.Context) error
+	Release()
+	ReserveSlots(n int) (*Reservation, error)
+	AddGroup(fs []func()) error
+	AddWeighted(f func(), weight int) error
+}
+
+// Inspector describes a pool and what it is doing.
+type Inspector interface {
+	Config() Config
+	Stats() Stats
+	String() string
+}
+
+// Diagnoser reports on the jobs of a pool, to help find slow or misbehaving jobs.
+type Diagnoser interface {
+	AddLabeled(label string, f func())
+	LatencyByLabel() map[string]LatencyStats
+	QueueLatencyPercentiles() LatencyPercentiles
+	Dropped() map[string]int64
+	LeakedGoroutines() int64
+	GoroutinesSpawned() int64
+}
+
+// PanicRecoverer controls what happens to jobs that panic.
+type PanicRecoverer interface {
+	SetPanicHandler(handler func(ctx context.Context, recovered any, stack []byte))
+	PanickedJobs() []func()
+}
+
+// pool is every method of the pools from New() and NewFixedSize().
+type pool interface {
+	Pool
+	Adder
+	ConditionalAdder
+	ErrorAdder
+	ContextAdder
+	Waiter
+	Pauser
+	Finisher
+	Drainer
+	Reconfigurer
+	Reserver
+	Inspector
+	Diagnoser
+	PanicRecoverer
+}
+
+var (
+	_ pool = (*fixedPool)(nil)
+	_ pool = (*dynamicPool)(nil)
+)
+
+// addOrErr adds f to p with AddOrErr() if p has it, and otherwise with Add(), for which no
+// error can be told.
+func addOrErr(p Pool, f func()) error {
+	if a, ok := p.(interface{ AddOrErr(f func()) error }); ok {
+		return a.AddOrErr(f)
+	}
+	p.Add(f)
+	return nil
+}
+
+// barrier returns p's Barrier() if p has it, and otherwise Wait(), which also waits on any
+// other jobs of p.
+func barrier(p Pool) func() {
+	if b, ok := p.(interface{ Barrier() func() }); ok {
+		return b.Barrier()
+	}
+	return p.Wait
+}
+
+// errOf returns p's Err() if p has it, and otherwise nil.
+func errOf(p Pool) error {
+	if e, ok := p.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
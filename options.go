@@ -0,0 +1,319 @@
+package threadpool
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures optional behavior of a Pool. Options are given to New(), NewFixedSize(),
+// NewWithOptions() or NewFixedSizeWithOptions().
+type Option func(*config)
+
+type config struct {
+	kind         PoolKind
+	concurrency  int
+	total        int
+	slotReclaim  time.Duration
+	panicHandler func(ctx context.Context, recovered any, stack []byte)
+	pauseBuffer  int
+	rampUp       time.Duration
+	gcBackoff    bool
+	rand         *rand.Rand
+	maxLifetime  time.Duration
+	maxDepth     int
+	nestedJobs   bool
+	stallAfter   time.Duration
+	onStall      func(jobID uint64, label string, running time.Duration)
+	cancelOnErr  bool
+	errThreshold int
+	progress     func(completed, total int)
+	rateLimit    rate.Limit
+	rateBurst    int
+	stallDetect  time.Duration
+	name         string
+}
+
+// Config describes the settings of a Pool once defaults have been applied. TotalJobs is -1
+// for pools without a fixed number of jobs, and the booleans report if the matching option
+// was given.
+type Config struct {
+	Kind              PoolKind
+	ConcurrentThreads int
+	TotalJobs         int
+	SlotReclaim       time.Duration
+	PanicHandler      bool
+	PauseBuffer       int
+	RampUp            time.Duration
+	GCBackoff         bool
+	RandSource        bool
+	MaxLifetime       time.Duration
+	MaxDepth          int
+	NestedJobs        bool
+	StallWarning      time.Duration
+	CancelOnError     bool
+	ErrorThreshold    int
+	Progress          bool
+	RateLimit         rate.Limit
+	RateBurst         int
+	StallDetector     time.Duration
+	Name              string
+}
+
+func (c *config) export() Config {
+	return Config{
+		Kind:              c.kind,
+		ConcurrentThreads: c.concurrency,
+		TotalJobs:         c.total,
+		SlotReclaim:       c.slotReclaim,
+		PanicHandler:      c.panicHandler != nil,
+		PauseBuffer:       c.pauseBuffer,
+		RampUp:            c.rampUp,
+		GCBackoff:         c.gcBackoff,
+		RandSource:        c.rand != nil,
+		MaxLifetime:       c.maxLifetime,
+		MaxDepth:          c.maxDepth,
+		NestedJobs:        c.nestedJobs,
+		StallWarning:      c.stallAfter,
+		CancelOnError:     c.cancelOnErr,
+		ErrorThreshold:    c.errThreshold,
+		Progress:          c.progress != nil,
+		RateLimit:         c.rateLimit,
+		RateBurst:         c.rateBurst,
+		StallDetector:     c.stallDetect,
+		Name:              c.name,
+	}
+}
+
+func newConfig(opts []Option) config {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithConcurrency sets the number of jobs ran at once, the concurrentThreads of New() and
+// NewFixedSize(), for NewWithOptions() and NewFixedSizeWithOptions(). If n is <=0 it will
+// assume runtime.NumCPU(), which is also the default.
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// WithTotal sets the totalJobs of NewFixedSize() for NewFixedSizeWithOptions().
+func WithTotal(n int) Option {
+	return func(c *config) {
+		c.total = n
+	}
+}
+
+// WithSlotReclaim releases a job's thread back to the pool once the job has run for longer
+// than after, and counts the job as completed for Wait().
+//
+//	Go cannot stop a goroutine, so the job keeps running in the background as a leaked
+//	goroutine until it returns on its own. This keeps a stuck job from holding a thread
+//	forever, at the cost of running more than concurrentThreads jobs at once. The number of
+//	such jobs still running is reported by LeakedGoroutines().
+//
+// If after is <=0 threads are never reclaimed.
+func WithSlotReclaim(after time.Duration) Option {
+	return func(c *config) {
+		c.slotReclaim = after
+	}
+}
+
+// WithPanicHandlerCtx recovers any panic from a job and calls handler with the pool's context,
+// the recovered value and the stack trace of the panic. The job is then treated as completed.
+//
+//	The context lets handler see if the pool is already finishing, and handler may call
+//	ForceFinish() itself to stop the remaining jobs.
+//
+// Without a panic handler the panic is still recovered, and is logged with the log package
+// along with its stack trace. The handler can be changed later with SetPanicHandler().
+func WithPanicHandlerCtx(handler func(ctx context.Context, recovered any, stack []byte)) Option {
+	return func(c *config) {
+		c.panicHandler = handler
+	}
+}
+
+// WithPanicHandler is WithPanicHandlerCtx() for a handler that has no use for the pool's
+// context, such as one counting panics in a metric so the rest of a batch carries on.
+func WithPanicHandler(handler func(recovered any, stack []byte)) Option {
+	return func(c *config) {
+		if handler == nil {
+			c.panicHandler = nil
+			return
+		}
+		c.panicHandler = func(_ context.Context, recovered any, stack []byte) {
+			handler(recovered, stack)
+		}
+	}
+}
+
+// WithPauseBuffer lets Add() take up to n jobs without blocking while the pool is paused.
+// The jobs start once Resume() is called, and only when the buffer is full does Add() block.
+// This keeps a short pause from holding up the callers of Add().
+func WithPauseBuffer(n int) Option {
+	return func(c *config) {
+		c.pauseBuffer = n
+	}
+}
+
+// WithRampUp frees the pool's threads gradually instead of all at once. One thread is free
+// straight away and the rest become free at even intervals, so all concurrentThreads are only
+// available after d. This avoids a spike of load on whatever the first jobs call.
+func WithRampUp(d time.Duration) Option {
+	return func(c *config) {
+		c.rampUp = d
+	}
+}
+
+// WithGCBackoff lowers the number of jobs running at once while the garbage collector runs
+// often, a sign the jobs are using more memory than is available. While the GC rate is high
+// one more thread is held back at each check, down to a single thread, and once it settles
+// the threads are given back one at a time.
+//
+//	The GC rate is checked in the background until the pool's context is done, so use
+//	ForceFinish() or cancel the context when done with the pool.
+func WithGCBackoff() Option {
+	return func(c *config) {
+		c.gcBackoff = true
+	}
+}
+
+// WithRandSource sets the source of randomness for anything the pool does at random, such
+// as picking which jobs are sampled by QueueLatencyPercentiles(). Giving pools sources with
+// the same seed makes their choices repeatable, which is useful in tests.
+//
+//	r is not safe for concurrent use, so it must not be shared with other pools or used
+//	elsewhere while the pool is in use. By default the math/rand package's source is used.
+func WithRandSource(r *rand.Rand) Option {
+	return func(c *config) {
+		c.rand = r
+	}
+}
+
+// WithMaxLifetime does a ForceFinish() once d has passed since the pool was created, however
+// many jobs are left. Err() then returns ErrMaxLifetime. This is a safety valve against a
+// batch running far longer than expected.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(c *config) {
+		c.maxLifetime = d
+	}
+}
+
+// WithMaxDepth limits how deeply jobs may add jobs to their own pool, to stop recursive jobs
+// fanning out without end. A job added from outside the pool has a depth of 1, and a job
+// added from within a job is one deeper than that job. Jobs deeper than n are not ran, Add()
+// drops them and AddOrErr() returns ErrMaxDepth, and Dropped() counts them as DropMaxDepth.
+// For NewFixedSize() they still count towards totalJobs.
+//
+//	The depth is found from the calling goroutine, so a job handing the adding off to
+//	another goroutine starts again at a depth of 1. This adds the cost of WithNestedJobs()
+//	to every job, and also gives its behavior. If n is <=0 the depth is not limited.
+func WithMaxDepth(n int) Option {
+	return func(c *config) {
+		c.maxDepth = n
+	}
+}
+
+// WithNestedJobs keeps track of the goroutines running the pool's jobs, so a job adding to its
+// own pool while no thread is free runs the new job inline rather than waiting, and jobs can
+// use Handoff(). Without it Add() from within a job waits on a free thread like any other
+// caller, which deadlocks once every thread is held by a job doing so, and Handoff() returns
+// false.
+//
+//	Finding the calling goroutine takes a stack trace, which is a real cost for every job
+//	ran, so it is only done when asked for. WithMaxDepth() keeps track of the jobs as well.
+func WithNestedJobs() Option {
+	return func(c *config) {
+		c.nestedJobs = true
+	}
+}
+
+// WithStallWarning calls onStall for any job still running once after has passed since it
+// started, to help find hung or slow jobs. The job is left running and onStall is called at
+// most once for it.
+//
+//	onStall is given the job's id, which counts up from 1 in the order jobs are added,
+//	the label given to AddLabeled() or "" for other jobs, and how long it has been
+//	running. It is called from its own goroutine. If after is <=0 or onStall is nil no
+//	warnings are given.
+func WithStallWarning(after time.Duration, onStall func(jobID uint64, label string, running time.Duration)) Option {
+	return func(c *config) {
+		c.stallAfter = after
+		c.onStall = onStall
+	}
+}
+
+// WithCancelOnError finishes the pool, like ForceFinish(), when a job from AddErr() or
+// AddRetryIf() first returns an error, in the manner of an errgroup. WaitErr() then returns
+// that error. Only the first error finishes the pool, although Errors() still has any later
+// ones from jobs already running.
+func WithCancelOnError() Option {
+	return func(c *config) {
+		c.cancelOnErr = true
+	}
+}
+
+// WithErrorThreshold finishes the pool, like ForceFinish(), once jobs from AddErr() or
+// AddRetryIf() have returned n errors, so a batch can tolerate a few failures but not many.
+// WaitErr() then returns the first n errors joined with errors.Join(). Errors() still has
+// any later ones from jobs already running. If n is <=0 the pool is never finished this
+// way, and n of 1 is WithCancelOnError() but with the error joined.
+func WithErrorThreshold(n int) Option {
+	return func(c *config) {
+		c.errThreshold = n
+	}
+}
+
+// WithProgress calls progress each time a job completes, with the number of jobs completed
+// so far and Total(), which is -1 for New(). It is called on the job's goroutine after the
+// job and before its thread is freed, so a slow progress holds back the pool; calls from
+// jobs completing together may arrive out of order.
+func WithProgress(progress func(completed, total int)) Option {
+	return func(c *config) {
+		c.progress = progress
+	}
+}
+
+// WithRateLimit limits how often jobs start to r per second, allowing bursts of up to burst
+// jobs, such as to keep within the quota of a downstream service. It works alongside the
+// limit on threads: a job holds its thread while it waits its turn. A job whose pool is
+// finished while it waits is not ran, and Dropped() counts it as DropRateLimited.
+//
+//	If r is <=0 or rate.Inf starts are not limited. If burst is <=0 it will assume 1.
+func WithRateLimit(r rate.Limit, burst int) Option {
+	return func(c *config) {
+		if burst <= 0 {
+			burst = 1
+		}
+		c.rateLimit = r
+		c.rateBurst = burst
+	}
+}
+
+// WithStallDetector logs a warning when jobs are outstanding but no thread has been freed for
+// longer than d. A job that never returns, such as one deadlocked, holds its thread for good,
+// and once every thread is held the pool silently stops making progress; this turns that into
+// a log line. It warns once for each stall, and again only after a thread has been freed.
+//
+//	Unlike WithStallWarning() it watches the pool as a whole rather than each job. If d
+//	is <=0 stalls are not detected.
+func WithStallDetector(d time.Duration) Option {
+	return func(c *config) {
+		c.stallDetect = d
+	}
+}
+
+// WithName names the pool, to tell it apart from others in logs. The name is given by
+// String() and Config().
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
@@ -0,0 +1,170 @@
+package threadpool
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Ordered runs jobs on a Pool and delivers their results in the order of the sequence
+// numbers they were added with. Other jobs added straight to the pool are not affected, so
+// ordered and unordered jobs can share one pool.
+//
+//	Results that complete ahead of an earlier sequence number are held until it is
+//	delivered, there is no limit on how many are held.
+type Ordered[T any] struct {
+	ctx     context.Context
+	pool    Pool
+	mux     sync.Mutex
+	added   int
+	closed  bool
+	pending map[int]T
+	skipped map[int]bool // seqs that will never have a result
+	skips   []int
+	ready   chan struct{}
+	results chan T
+}
+
+// NewOrdered creates an Ordered running its jobs on p. Close() must be called once all jobs
+// are added for Results() to be closed. If p is finished, with ForceFinish() for instance,
+// Results() is closed after delivering the results in order up to the first missing one. If
+// ctx is done Results() is closed without waiting on the remaining results, so a reader that
+// stops early does not leave the Ordered blocked. A nil ctx is treated as
+// context.Background().
+func NewOrdered[T any](ctx context.Context, p Pool) *Ordered[T] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	o := &Ordered[T]{
+		ctx:     ctx,
+		pool:    p,
+		pending: make(map[int]T),
+		skipped: make(map[int]bool),
+		ready:   make(chan struct{}, 1),
+		results: make(chan T),
+	}
+	go o.deliver()
+	return o
+}
+
+// AddOrdered adds f to the pool like AddOrErr(), blocking until a free thread can work on
+// it, and delivers its result once the results of every lower seq are delivered.
+//
+//	seq must count up from 0 without gaps or repeats, though the jobs may be added in
+//	any order. Jobs added after Close() are not ran. A job the pool will not take, such
+//	as one past totalJobs, or one that panics has no result, its seq is skipped and is
+//	reported by Skipped().
+func (o *Ordered[T]) AddOrdered(seq int, f func() T) {
+	o.mux.Lock()
+	if o.closed {
+		o.mux.Unlock()
+		return
+	}
+	o.added++
+	o.mux.Unlock()
+
+	err := addOrErr(o.pool, func() {
+		completed := false
+		defer func() {
+			// a panic carries on to the pool once seq is skipped
+			if !completed {
+				o.skip(seq)
+			}
+		}()
+		v := f()
+		completed = true
+
+		o.mux.Lock()
+		o.pending[seq] = v
+		o.mux.Unlock()
+		o.signal()
+	})
+	if err != nil {
+		o.skip(seq)
+	}
+}
+
+// skip records that seq will never have a result, so the results after it are not held.
+func (o *Ordered[T]) skip(seq int) {
+	o.mux.Lock()
+	o.skipped[seq] = true
+	o.skips = append(o.skips, seq)
+	o.mux.Unlock()
+	o.signal()
+}
+
+// Skipped returns the seqs, in order, whose jobs were not added to the pool or panicked, so
+// they have no result.
+func (o *Ordered[T]) Skipped() []int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	skips := append([]int(nil), o.skips...)
+	sort.Ints(skips)
+	return skips
+}
+
+// Results returns the channel the results are delivered on in seq order.
+func (o *Ordered[T]) Results() <-chan T {
+	return o.results
+}
+
+// Close stops any more jobs from being added. Results() is closed once the results of the
+// jobs already added are delivered. It is safe to call more than once.
+func (o *Ordered[T]) Close() {
+	o.mux.Lock()
+	o.closed = true
+	o.mux.Unlock()
+	o.signal()
+}
+
+func (o *Ordered[T]) signal() {
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Ordered[T]) deliver() {
+	defer close(o.results)
+
+	var finished <-chan struct{}
+	if pd, ok := o.pool.(poolDone); ok {
+		finished = pd.finished()
+	}
+
+	next := 0
+	for {
+		o.mux.Lock()
+		v, ok := o.pending[next]
+		delete(o.pending, next)
+		skipped := o.skipped[next]
+		delete(o.skipped, next)
+		done := !ok && !skipped && o.closed && next == o.added
+		o.mux.Unlock()
+
+		if skipped {
+			next++
+			continue
+		}
+		if ok {
+			select {
+			case o.results <- v:
+			case <-o.ctx.Done():
+				return
+			}
+			next++
+			continue
+		}
+		if done {
+			return
+		}
+		select {
+		case <-o.ready:
+		case <-finished:
+			return
+		case <-o.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package threadpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrdered(t *testing.T) {
+	total := 30
+	h := New(context.Background(), 4)
+	o := NewOrdered[int](context.Background(), h)
+
+	var unordered int32
+	go func() {
+		defer o.Close()
+		for i := 0; i < total; i++ {
+			i := i
+			o.AddOrdered(i, func() int {
+				// later jobs tend to finish first
+				time.Sleep(time.Duration((i*7)%5) * time.Millisecond)
+				return i
+			})
+			h.Add(func() {
+				time.Sleep(time.Duration((i*3)%4) * time.Millisecond)
+				atomic.AddInt32(&unordered, 1)
+			})
+		}
+	}()
+
+	expected := 0
+	for v := range o.Results() {
+		if v != expected {
+			t.Fatalf("expected %v but found %v", expected, v)
+		}
+		expected++
+	}
+	if expected != total {
+		t.Fatalf("expected %v but found %v", total, expected)
+	}
+
+	h.Wait()
+	if unordered != int32(total) {
+		t.Fatalf("expected %v but found %v", total, unordered)
+	}
+}
+
+func TestOrdered_Skipped(t *testing.T) {
+	h := New(context.Background(), 2, WithPanicHandler(func(any, []byte) {}))
+	o := NewOrdered[int](context.Background(), h)
+	for i := 0; i < 5; i++ {
+		i := i
+		o.AddOrdered(i, func() int {
+			if i == 2 {
+				panic("boom")
+			}
+			return i
+		})
+	}
+	o.Close()
+
+	var actual []int
+	for v := range o.Results() {
+		actual = append(actual, v)
+	}
+	expected := []int{0, 1, 3, 4}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v but found %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %v but found %v", expected, actual)
+		}
+	}
+	if s := o.Skipped(); len(s) != 1 || s[0] != 2 {
+		t.Fatalf("expected %v but found %v", []int{2}, s)
+	}
+
+	// a draining pool takes none of the jobs, Results still closes
+	d := full(New(context.Background(), 2))
+	d.Drain()
+	o = NewOrdered[int](context.Background(), d)
+	for i := 0; i < 3; i++ {
+		o.AddOrdered(i, func() int { return 1 })
+	}
+	o.Close()
+	select {
+	case _, ok := <-o.Results():
+		if ok {
+			t.Fatalf("expected no results from a draining pool")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Results to close once every job was dropped")
+	}
+	if s := o.Skipped(); len(s) != 3 {
+		t.Fatalf("expected %v but found %v", 3, len(s))
+	}
+}
+
+func TestOrdered_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := New(context.Background(), 2)
+	o := NewOrdered[int](ctx, h)
+	for i := 0; i < 5; i++ {
+		i := i
+		o.AddOrdered(i, func() int { return i })
+	}
+	h.Wait()
+
+	// the reader stops after one result, cancelling ctx ends delivery
+	if v := <-o.Results(); v != 0 {
+		t.Fatalf("expected %v but found %v", 0, v)
+	}
+	cancel()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-o.Results():
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("expected Results to close once ctx was done")
+		}
+	}
+}
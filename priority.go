@@ -0,0 +1,315 @@
+package threadpool
+
+import (
+	"container/heap"
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// PriorityPool is a thread pool whose queued jobs start highest priority first, so urgent
+// jobs jump ahead of a backlog of less important ones. Jobs of equal priority start in the
+// order they were added, unless WithScheduler() orders them some other way. Like
+// NewPersistent() it has a fixed set of long lived workers.
+type PriorityPool struct {
+	mux       sync.Mutex
+	cond      *sync.Cond
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	queue     priorityQueue
+	added     uint64
+	closed    bool
+	finishing int32
+	threads   int
+	requeues  int     // from WithRequeueOnPanic()
+	busy      []int32 // 1 while the worker of that index runs a job
+	wg        counter
+	workers   sync.WaitGroup
+}
+
+var _ Pool = (*PriorityPool)(nil)
+
+// QueuedJob describes a job waiting in a PriorityPool, as given to the comparator of
+// WithScheduler().
+type QueuedJob struct {
+	// Priority is the priority given to AddPriority(), 0 for Add() and AddNoWait().
+	Priority int
+	// Seq is the order the job was added in, counting from 1.
+	Seq uint64
+}
+
+// PriorityOption configures a PriorityPool.
+type PriorityOption func(*priorityConfig)
+
+type priorityConfig struct {
+	before   func(a, b QueuedJob) bool
+	requeues int
+}
+
+// WithScheduler orders the queued jobs of a PriorityPool with before, which returns true if a
+// is to start before b, in place of the highest priority first. Jobs before treats as equal
+// may start in any order, so compare Seq as well to keep them in the order added.
+//
+//	before is called with the pool's lock held, so it must be quick and must not call the
+//	pool. A nil before keeps the default order.
+func WithScheduler(before func(a, b QueuedJob) bool) PriorityOption {
+	return func(c *priorityConfig) {
+		c.before = before
+	}
+}
+
+// WithRequeueOnPanic queues a job of a PriorityPool that panics again, up to maxRequeues
+// times, so work is not lost to a passing condition. The job goes back through the queue
+// with a new Seq, letting the jobs queued meanwhile have their turn first, and only a panic
+// once it has been requeued maxRequeues times is logged. If maxRequeues is <=0, which is the
+// default, a job is never requeued.
+//
+//	The job must be safe to run again, including after being stopped part way through. A
+//	job is not requeued once the pool is finished.
+func WithRequeueOnPanic(maxRequeues int) PriorityOption {
+	return func(c *priorityConfig) {
+		c.requeues = maxRequeues
+	}
+}
+
+// highestPriority is the default order of a PriorityPool, the highest priority and then the
+// lowest seq first.
+func highestPriority(a, b QueuedJob) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.Seq < b.Seq
+}
+
+type prioritized struct {
+	f func()
+	QueuedJob
+	requeued int // times the job was queued again after panicking
+}
+
+// priorityQueue is a heap.Interface with the jobs before puts first at the top.
+type priorityQueue struct {
+	jobs   []prioritized
+	before func(a, b QueuedJob) bool
+}
+
+func (q *priorityQueue) Len() int { return len(q.jobs) }
+
+func (q *priorityQueue) Less(i, j int) bool {
+	return q.before(q.jobs[i].QueuedJob, q.jobs[j].QueuedJob)
+}
+
+func (q *priorityQueue) Swap(i, j int) { q.jobs[i], q.jobs[j] = q.jobs[j], q.jobs[i] }
+
+func (q *priorityQueue) Push(x any) { q.jobs = append(q.jobs, x.(prioritized)) }
+
+func (q *priorityQueue) Pop() any {
+	old := q.jobs
+	x := old[len(old)-1]
+	old[len(old)-1] = prioritized{}
+	q.jobs = old[:len(old)-1]
+	return x
+}
+
+// NewPriorityPool creates a PriorityPool with concurrentThreads workers. The workers run until
+// the pool is finished, so use ForceFinish() or cancel ctx when done with the pool.
+//
+// If concurrentThreads is <=0 it will assume runtime.NumCPU(). A nil ctx is treated as
+// context.Background().
+func NewPriorityPool(ctx context.Context, concurrentThreads int, opts ...PriorityOption) *PriorityPool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if concurrentThreads <= 0 {
+		concurrentThreads = runtime.NumCPU()
+	}
+	var cfg priorityConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.before == nil {
+		cfg.before = highestPriority
+	}
+
+	cCtx, can := context.WithCancel(ctx)
+	p := &PriorityPool{
+		ctx:       cCtx,
+		ctxCancel: can,
+		queue:     priorityQueue{before: cfg.before},
+		threads:   concurrentThreads,
+		requeues:  cfg.requeues,
+		busy:      make([]int32, concurrentThreads),
+	}
+	p.cond = sync.NewCond(&p.mux)
+	context.AfterFunc(cCtx, p.drop)
+	p.workers.Add(concurrentThreads)
+	for i := 0; i < concurrentThreads; i++ {
+		go p.work(i)
+	}
+	return p
+}
+
+// AddPriority queues a new job to be ran once a worker is free and no job of a higher
+// priority, or of the same priority added earlier, is queued, or in the order given by
+// WithScheduler(). It does not block. The job is dropped if the pool is finished or closed.
+func (p *PriorityPool) AddPriority(f func(), priority int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.closed || p.ctx.Err() != nil {
+		return
+	}
+	p.wg.Add(1)
+	p.added++
+	heap.Push(&p.queue, prioritized{f: f, QueuedJob: QueuedJob{Priority: priority, Seq: p.added}})
+	p.cond.Signal()
+}
+
+// Add queues a new job with a priority of 0, like AddPriority().
+func (p *PriorityPool) Add(f func()) {
+	p.AddPriority(f, 0)
+}
+
+// AddNoWait is Add(), which already does not block.
+func (p *PriorityPool) AddNoWait(f func()) {
+	p.AddPriority(f, 0)
+}
+
+// work is the loop of the worker numbered worker, running jobs until the pool is finished.
+func (p *PriorityPool) work(worker int) {
+	defer p.workers.Done()
+	for {
+		p.mux.Lock()
+		for p.queue.Len() == 0 && !p.closed && p.ctx.Err() == nil {
+			p.cond.Wait()
+		}
+		if p.queue.Len() == 0 || p.ctx.Err() != nil {
+			p.mux.Unlock()
+			return
+		}
+		job := heap.Pop(&p.queue).(prioritized)
+		p.mux.Unlock()
+
+		p.run(worker, job)
+	}
+}
+
+// run runs job on worker, recovering any panic so the worker carries on. The panic is logged
+// unless the job is queued again by requeue().
+func (p *PriorityPool) run(worker int, job prioritized) {
+	requeued := false
+	defer func() {
+		// a requeued job is still waited on
+		if !requeued {
+			p.wg.Done()
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			if requeued = p.requeue(job); !requeued {
+				logPanic(p.ctx, r, debug.Stack())
+			}
+		}
+	}()
+	atomic.StoreInt32(&p.busy[worker], 1)
+	defer atomic.StoreInt32(&p.busy[worker], 0)
+	job.f()
+}
+
+// requeue queues job again after it panicked and returns true, or returns false if it was
+// requeued WithRequeueOnPanic() times already or the pool is finished.
+func (p *PriorityPool) requeue(job prioritized) bool {
+	if job.requeued >= p.requeues {
+		return false
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.ctx.Err() != nil {
+		return false
+	}
+	job.requeued++
+	p.added++
+	job.Seq = p.added
+	heap.Push(&p.queue, job)
+	p.cond.Signal()
+	return true
+}
+
+// drop drops the queued jobs and wakes the workers so they stop, once the pool is finished.
+func (p *PriorityPool) drop() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	for range p.queue.jobs {
+		p.wg.Done()
+	}
+	p.queue.jobs = nil
+	p.cond.Broadcast()
+}
+
+// ForceFinish provides an easy method to drop all queued jobs and stop the workers. Jobs
+// already running are not stopped.
+func (p *PriorityPool) ForceFinish() {
+	p.ForceFinished()
+}
+
+// ForceFinished is ForceFinish() returning true if this call finished the pool, and false if
+// it was already finished, so only one caller acts on the shutdown.
+func (p *PriorityPool) ForceFinished() bool {
+	first := p.ctx.Err() == nil && atomic.CompareAndSwapInt32(&p.finishing, 0, 1)
+	p.ctxCancel()
+	p.drop()
+	return first
+}
+
+// Kind returns Priority.
+func (p *PriorityPool) Kind() PoolKind {
+	return Priority
+}
+
+// Concurrency returns the number of workers, the concurrentThreads the pool was created with
+// after defaults were applied.
+func (p *PriorityPool) Concurrency() int {
+	return p.threads
+}
+
+// Total returns -1, as the pool does not have a fixed number of jobs.
+func (p *PriorityPool) Total() int {
+	return -1
+}
+
+// IsDone returns true once the pool is finished, by ForceFinish(), Close() or its context,
+// and will not run any more jobs.
+func (p *PriorityPool) IsDone() bool {
+	return p.ctx.Err() != nil
+}
+
+// WorkerStates returns which workers are running a job, true at the index of each busy
+// worker. It is a snapshot, the workers may have moved on by the time it returns.
+func (p *PriorityPool) WorkerStates() []bool {
+	return workerStates(p.busy)
+}
+
+// Wait when called will block until all queued and running jobs are completed. Any number of
+// goroutines may wait at once, even while jobs are being added.
+func (p *PriorityPool) Wait() {
+	p.wg.Wait()
+}
+
+// Close lets the queued jobs complete and then stops the workers, returning once they are all
+// gone. Any more jobs are dropped. It is safe to call more than once, but not from within a
+// job as it would wait on itself. It always returns nil.
+func (p *PriorityPool) Close() error {
+	p.mux.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mux.Unlock()
+
+	p.workers.Wait()
+	p.ctxCancel()
+	return nil
+}
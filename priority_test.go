@@ -0,0 +1,210 @@
+package threadpool
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityPool(t *testing.T) {
+	p := NewPriorityPool(context.Background(), 1)
+	defer p.ForceFinish()
+
+	// hold the only worker so the rest of the jobs queue up
+	block := make(chan bool)
+	started := make(chan bool)
+	p.Add(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	mux := sync.Mutex{}
+	var order []int
+	record := func(i int) func() {
+		return func() {
+			mux.Lock()
+			order = append(order, i)
+			mux.Unlock()
+		}
+	}
+	p.AddPriority(record(0), 0)
+	p.AddPriority(record(1), 0)
+	p.AddPriority(record(2), 5)
+	p.AddPriority(record(3), -1)
+	p.AddPriority(record(4), 5)
+	close(block)
+	p.Wait()
+
+	expected := []int{2, 4, 0, 1, 3}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected %v but found %v", expected, order)
+		}
+	}
+}
+
+func TestPriorityPool_ForceFinish(t *testing.T) {
+	p := NewPriorityPool(context.Background(), 1)
+	block := make(chan bool)
+	started := make(chan bool)
+	p.Add(func() {
+		close(started)
+		<-block
+	})
+	<-started
+	for i := 0; i < 10; i++ {
+		p.Add(func() { t.Errorf("expected the queued job to be dropped") })
+	}
+
+	if !p.ForceFinished() {
+		t.Fatalf("expected %v for the first call", true)
+	}
+	if p.ForceFinished() {
+		t.Fatalf("expected %v once already finished", false)
+	}
+	close(block)
+	done := make(chan bool)
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Wait to return after ForceFinish")
+	}
+}
+
+func TestPriorityPool_Close(t *testing.T) {
+	p := NewPriorityPool(context.Background(), 2)
+
+	mux := sync.Mutex{}
+	runs := 0
+	for i := 0; i < 8; i++ {
+		p.AddPriority(func() {
+			time.Sleep(time.Millisecond)
+			mux.Lock()
+			runs++
+			mux.Unlock()
+		}, i)
+	}
+	p.Close()
+	if runs != 8 {
+		t.Fatalf("expected %v but found %v", 8, runs)
+	}
+
+	p.Add(func() { t.Errorf("expected the job to be dropped") })
+	p.AddNoWait(func() { t.Errorf("expected the job to be dropped") })
+	p.Wait()
+	if err := p.Close(); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+}
+
+func TestPriorityPool_WithScheduler(t *testing.T) {
+	// the lowest priority first, and the last added first among equals
+	p := NewPriorityPool(context.Background(), 1, WithScheduler(func(a, b QueuedJob) bool {
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Seq > b.Seq
+	}))
+	defer p.Close()
+
+	block := make(chan bool)
+	started := make(chan bool)
+	p.Add(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	mux := sync.Mutex{}
+	var order []int
+	record := func(i int) func() {
+		return func() {
+			mux.Lock()
+			order = append(order, i)
+			mux.Unlock()
+		}
+	}
+	p.AddPriority(record(0), 0)
+	p.AddPriority(record(1), 0)
+	p.AddPriority(record(2), 5)
+	p.AddPriority(record(3), -1)
+	p.AddPriority(record(4), 5)
+	close(block)
+	p.Wait()
+
+	expected := []int{3, 1, 0, 4, 2}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected %v but found %v", expected, order)
+		}
+	}
+}
+
+func TestPriorityPool_WithRequeueOnPanic(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	p := NewPriorityPool(context.Background(), 1, WithRequeueOnPanic(2))
+	defer p.Close()
+
+	block := make(chan bool)
+	started := make(chan bool)
+	p.Add(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	mux := sync.Mutex{}
+	var order []string
+	record := func(s string) {
+		mux.Lock()
+		order = append(order, s)
+		mux.Unlock()
+	}
+	runs := 0
+	p.Add(func() {
+		runs++
+		record("flaky")
+		if runs < 3 {
+			panic("boom")
+		}
+	})
+	p.Add(func() { record("other") })
+	close(block)
+	p.Wait()
+
+	// the requeued job goes to the back of the queue
+	expected := []string{"flaky", "other", "flaky", "flaky"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v but found %v", expected, order)
+	}
+	if logged.Len() != 0 {
+		t.Fatalf("expected no panic to be logged but found %q", logged.String())
+	}
+
+	// a job still panicking after maxRequeues is logged once
+	runs = 0
+	p.Add(func() {
+		runs++
+		panic("boom")
+	})
+	p.Wait()
+	if runs != 3 {
+		t.Fatalf("expected %v but found %v", 3, runs)
+	}
+	if n := strings.Count(logged.String(), "recovered panic"); n != 1 {
+		t.Fatalf("expected %v but found %v", 1, n)
+	}
+}
@@ -0,0 +1,67 @@
+// Package promcollector exports the Stats() of a threadpool.Inspector as Prometheus metrics.
+//
+// It is a module of its own so that only those who use it depend on the Prometheus client.
+package promcollector
+
+import (
+	"github.com/nathanhack/threadpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector reporting the Stats() of a pool. The stats are read on
+// each scrape, nothing is kept between them.
+//
+//	Each metric has a "pool" label holding the name given by threadpool.WithName(), so
+//	pools given different names can be registered side by side.
+type Collector struct {
+	pool threadpool.Inspector
+
+	running   *prometheus.Desc
+	available *prometheus.Desc
+	pending   *prometheus.Desc
+	waiting   *prometheus.Desc
+	completed *prometheus.Desc
+	panicked  *prometheus.Desc
+}
+
+// New creates a Collector for p, to be given to prometheus.Register() or the Register() of
+// a prometheus.Registry. The pools from threadpool.New() and threadpool.NewFixedSize() are
+// all Inspectors.
+func New(p threadpool.Inspector) *Collector {
+	labels := prometheus.Labels{"pool": p.Config().Name}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("threadpool", "", name), help, nil, labels)
+	}
+
+	return &Collector{
+		pool:      p,
+		running:   desc("running_threads", "Number of threads in use."),
+		available: desc("available_threads", "Number of free threads."),
+		pending:   desc("pending_jobs", "Number of jobs left of totalJobs, always 0 for a dynamic pool."),
+		waiting:   desc("waiting_jobs", "Number of added jobs waiting on a free thread."),
+		completed: desc("completed_jobs_total", "Number of jobs that have returned, including those that panicked."),
+		panicked:  desc("panicked_jobs_total", "Number of jobs whose panic was recovered."),
+	}
+}
+
+// Describe sends the descriptions of the metrics to ch.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.running
+	ch <- c.available
+	ch <- c.pending
+	ch <- c.waiting
+	ch <- c.completed
+	ch <- c.panicked
+}
+
+// Collect sends the pool's current stats to ch.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, float64(s.Running))
+	ch <- prometheus.MustNewConstMetric(c.available, prometheus.GaugeValue, float64(s.Available))
+	ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(s.Pending))
+	ch <- prometheus.MustNewConstMetric(c.waiting, prometheus.GaugeValue, float64(s.Waiting))
+	ch <- prometheus.MustNewConstMetric(c.completed, prometheus.CounterValue, float64(s.Completed))
+	ch <- prometheus.MustNewConstMetric(c.panicked, prometheus.CounterValue, float64(s.Panicked))
+}
@@ -0,0 +1,72 @@
+package promcollector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathanhack/threadpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	p := threadpool.NewFixedSize(context.Background(), 2, 4,
+		threadpool.WithName("batch"),
+		threadpool.WithPanicHandler(func(any, []byte) {}),
+	)
+	p.Add(func() {})
+	p.Add(func() { panic("boom") })
+	p.Add(func() {})
+	p.(threadpool.Waiter).WaitN(3)
+
+	// a job is counted as completed just before its thread is freed
+	inspector := p.(threadpool.Inspector)
+	deadline := time.Now().Add(time.Second)
+	for inspector.Stats().Available != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(New(inspector)); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+
+	expected := `
+# HELP threadpool_available_threads Number of free threads.
+# TYPE threadpool_available_threads gauge
+threadpool_available_threads{pool="batch"} 2
+# HELP threadpool_completed_jobs_total Number of jobs that have returned, including those that panicked.
+# TYPE threadpool_completed_jobs_total counter
+threadpool_completed_jobs_total{pool="batch"} 3
+# HELP threadpool_panicked_jobs_total Number of jobs whose panic was recovered.
+# TYPE threadpool_panicked_jobs_total counter
+threadpool_panicked_jobs_total{pool="batch"} 1
+# HELP threadpool_pending_jobs Number of jobs left of totalJobs, always 0 for a dynamic pool.
+# TYPE threadpool_pending_jobs gauge
+threadpool_pending_jobs{pool="batch"} 1
+# HELP threadpool_running_threads Number of threads in use.
+# TYPE threadpool_running_threads gauge
+threadpool_running_threads{pool="batch"} 0
+# HELP threadpool_waiting_jobs Number of added jobs waiting on a free thread.
+# TYPE threadpool_waiting_jobs gauge
+threadpool_waiting_jobs{pool="batch"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollector_TwoPools(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(New(threadpool.New(context.Background(), 1, threadpool.WithName("a")).(threadpool.Inspector))); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+	if err := reg.Register(New(threadpool.New(context.Background(), 1, threadpool.WithName("b")).(threadpool.Inspector))); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+	if err := reg.Register(New(threadpool.New(context.Background(), 1, threadpool.WithName("a")).(threadpool.Inspector))); err == nil {
+		t.Fatalf("expected an error registering a second pool named %q", "a")
+	}
+}
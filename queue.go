@@ -0,0 +1,309 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// QueuePool is a thread pool with a fixed set of long lived workers taking jobs from a
+// bounded queue. Unlike the pools from New() and NewFixedSize() no goroutine is started per
+// job, so the number of goroutines stays at concurrentThreads however many jobs are queued.
+type QueuePool struct {
+	mux       sync.RWMutex // held for reading while queuing, so Close() can close jobs
+	closed    bool
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	jobs      chan func()
+	spillMux  sync.Mutex
+	spilled   []func()      // jobs from AddNoWait() that found the queue full
+	spill     chan struct{} // wakes a worker once a job is spilled
+	finishing int32
+	threads   int
+	busy      []int32 // 1 while the worker of that index runs a job
+	wg        counter
+	workers   sync.WaitGroup
+}
+
+var _ Pool = (*QueuePool)(nil)
+
+// NewQueuePool creates a QueuePool with concurrentThreads workers and room for queueSize jobs
+// waiting on a worker. The workers run until the pool is finished, so use ForceFinish() or
+// cancel ctx when done with the pool.
+//
+// If concurrentThreads is <=0 it will assume runtime.NumCPU(). If queueSize is <0 it will
+// assume 0, so Add() waits for a worker to take the job. A nil ctx is treated as
+// context.Background().
+func NewQueuePool(ctx context.Context, concurrentThreads, queueSize int) *QueuePool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if concurrentThreads <= 0 {
+		concurrentThreads = runtime.NumCPU()
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	cCtx, can := context.WithCancel(ctx)
+	p := &QueuePool{
+		ctx:       cCtx,
+		ctxCancel: can,
+		jobs:      make(chan func(), queueSize),
+		spill:     make(chan struct{}, 1),
+		threads:   concurrentThreads,
+		busy:      make([]int32, concurrentThreads),
+	}
+	p.workers.Add(concurrentThreads)
+	for i := 0; i < concurrentThreads; i++ {
+		go p.work(i)
+	}
+	return p
+}
+
+// NewPersistent creates a Pool of exactly concurrentThreads workers started up front, each
+// looping over the queued jobs. It is for many small jobs, where starting a goroutine per job
+// as New() does is a measurable cost. The pool is a *QueuePool whose queue holds
+// concurrentThreads jobs.
+//
+// If concurrentThreads is <=0 it will assume runtime.NumCPU(). A nil ctx is treated as
+// context.Background().
+func NewPersistent(ctx context.Context, concurrentThreads int) Pool {
+	if concurrentThreads <= 0 {
+		concurrentThreads = runtime.NumCPU()
+	}
+	return NewQueuePool(ctx, concurrentThreads, concurrentThreads)
+}
+
+// Add queues a new job to be ran, blocking while the queue is full. The job is dropped if the
+// pool is finished or closed first.
+func (p *QueuePool) Add(f func()) {
+	p.wg.Add(1)
+	p.push(f)
+}
+
+// AddNoWait queues a new job to be ran without blocking. A job that finds the queue full is
+// held in an overflow list the workers take from as well, so jobs can add to their own pool
+// without waiting on the workers running them. No goroutine is started for it, so the
+// goroutines stay at concurrentThreads. The job is dropped if the pool is finished or closed.
+func (p *QueuePool) AddNoWait(f func()) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	if p.closed || p.ctx.Err() != nil {
+		return
+	}
+
+	p.wg.Add(1)
+	select {
+	case p.jobs <- f:
+	default:
+		p.spillMux.Lock()
+		p.spilled = append(p.spilled, f)
+		p.spillMux.Unlock()
+		select {
+		case p.spill <- struct{}{}:
+		default:
+		}
+	}
+	p.dropIfDone()
+}
+
+// unspill takes the oldest job from the overflow list, returning false if it is empty.
+func (p *QueuePool) unspill() (func(), bool) {
+	p.spillMux.Lock()
+	defer p.spillMux.Unlock()
+
+	if len(p.spilled) == 0 {
+		return nil, false
+	}
+	f := p.spilled[0]
+	p.spilled[0] = nil
+	p.spilled = p.spilled[1:]
+	return f, true
+}
+
+// push queues f for a job already counted in wg.
+func (p *QueuePool) push(f func()) {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	if p.closed {
+		p.wg.Done()
+		return
+	}
+	select {
+	case p.jobs <- f:
+	case <-p.ctx.Done():
+		p.wg.Done()
+		return
+	}
+	p.dropIfDone()
+}
+
+// TryAdd queues a new job to be ran if there is room in the queue, or a worker free to take
+// it, and returns true. Otherwise it returns false straight away without queuing the job,
+// as it also does once the pool is finished or closed.
+func (p *QueuePool) TryAdd(f func()) bool {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+
+	if p.closed || p.ctx.Err() != nil {
+		return false
+	}
+
+	p.wg.Add(1)
+	select {
+	case p.jobs <- f:
+	default:
+		p.wg.Done()
+		return false
+	}
+	p.dropIfDone()
+	return true
+}
+
+// dropIfDone drops the queued jobs if the pool is finished. It is called after queuing a
+// job, so a job queued as the pool finishes is not left behind once the workers are gone.
+func (p *QueuePool) dropIfDone() {
+	if p.ctx.Err() == nil {
+		return
+	}
+	for _, ok := p.unspill(); ok; _, ok = p.unspill() {
+		p.wg.Done()
+	}
+	for {
+		select {
+		case _, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			p.wg.Done()
+		default:
+			return
+		}
+	}
+}
+
+// work is the loop of the worker numbered worker, running jobs until the pool is finished.
+func (p *QueuePool) work(worker int) {
+	defer p.workers.Done()
+	for {
+		if f, ok := p.unspill(); ok {
+			p.runUnlessDone(worker, f)
+			continue
+		}
+		select {
+		case f, ok := <-p.jobs:
+			if !ok {
+				// Close() lets the spilled jobs complete too
+				for f, ok := p.unspill(); ok; f, ok = p.unspill() {
+					p.runUnlessDone(worker, f)
+				}
+				return
+			}
+			p.runUnlessDone(worker, f)
+		case <-p.spill:
+		case <-p.ctx.Done():
+			p.dropIfDone()
+			return
+		}
+	}
+}
+
+// runUnlessDone runs f on worker, or drops it if the pool is finished.
+func (p *QueuePool) runUnlessDone(worker int, f func()) {
+	if p.ctx.Err() != nil {
+		p.wg.Done()
+		return
+	}
+	p.run(worker, f)
+}
+
+// run runs f on worker, recovering and logging any panic so the worker carries on.
+func (p *QueuePool) run(worker int, f func()) {
+	defer p.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			logPanic(p.ctx, r, debug.Stack())
+		}
+	}()
+	atomic.StoreInt32(&p.busy[worker], 1)
+	defer atomic.StoreInt32(&p.busy[worker], 0)
+	f()
+}
+
+// ForceFinish provides an easy method to drop all queued jobs and stop the workers. Jobs
+// already running are not stopped.
+func (p *QueuePool) ForceFinish() {
+	p.ForceFinished()
+}
+
+// ForceFinished is ForceFinish() returning true if this call finished the pool, and false if
+// it was already finished, so only one caller acts on the shutdown.
+func (p *QueuePool) ForceFinished() bool {
+	first := p.ctx.Err() == nil && atomic.CompareAndSwapInt32(&p.finishing, 0, 1)
+	p.ctxCancel()
+	return first
+}
+
+// Kind returns Queue.
+func (p *QueuePool) Kind() PoolKind {
+	return Queue
+}
+
+// Concurrency returns the number of workers, the concurrentThreads the pool was created with
+// after defaults were applied.
+func (p *QueuePool) Concurrency() int {
+	return p.threads
+}
+
+// Total returns -1, as the pool does not have a fixed number of jobs.
+func (p *QueuePool) Total() int {
+	return -1
+}
+
+// IsDone returns true once the pool is finished, by ForceFinish(), Close() or its context,
+// and will not run any more jobs.
+func (p *QueuePool) IsDone() bool {
+	return p.ctx.Err() != nil
+}
+
+// WorkerStates returns which workers are running a job, true at the index of each busy
+// worker. It is a snapshot, the workers may have moved on by the time it returns.
+func (p *QueuePool) WorkerStates() []bool {
+	return workerStates(p.busy)
+}
+
+// Wait when called will block until all queued and running jobs are completed. Any number of
+// goroutines may wait at once, even while jobs are being added.
+func (p *QueuePool) Wait() {
+	p.wg.Wait()
+}
+
+// Close lets the queued jobs complete and then stops the workers, returning once they are all
+// gone. Any more jobs are dropped, and TryAdd() returns false. It is safe to call more than
+// once, but not from within a job as it would wait on itself. It always returns nil.
+func (p *QueuePool) Close() error {
+	p.mux.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mux.Unlock()
+
+	p.workers.Wait()
+	p.ctxCancel()
+	return nil
+}
+
+// workerStates returns a snapshot of busy, true at the index of each worker running a job.
+func workerStates(busy []int32) []bool {
+	states := make([]bool, len(busy))
+	for i := range busy {
+		states[i] = atomic.LoadInt32(&busy[i]) == 1
+	}
+	return states
+}
@@ -0,0 +1,283 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueuePool(t *testing.T) {
+	concur := 4
+	before := runtime.NumGoroutine()
+	p := NewQueuePool(context.Background(), concur, 16)
+	defer p.ForceFinish()
+
+	total := 10000
+	var runs int32
+	for i := 0; i < total; i++ {
+		p.Add(func() { atomic.AddInt32(&runs, 1) })
+		if i%1000 == 0 {
+			if n := runtime.NumGoroutine() - before; n > concur {
+				t.Fatalf("expected at most %v goroutines but found %v", concur, n)
+			}
+		}
+	}
+	p.Wait()
+	if runs != int32(total) {
+		t.Fatalf("expected %v but found %v", total, runs)
+	}
+}
+
+func TestQueuePool_TryAdd(t *testing.T) {
+	p := NewQueuePool(context.Background(), 1, 2)
+	block := make(chan bool)
+	started := make(chan bool)
+	p.Add(func() {
+		close(started)
+		<-block
+	})
+	<-started
+
+	var runs int32
+	for i := 0; i < 2; i++ {
+		if !p.TryAdd(func() { atomic.AddInt32(&runs, 1) }) {
+			t.Fatalf("expected TryAdd to succeed with room in the queue")
+		}
+	}
+	if p.TryAdd(func() { atomic.AddInt32(&runs, 1) }) {
+		t.Fatalf("expected TryAdd to fail with the queue full")
+	}
+	close(block)
+	p.Wait()
+	if runs != 2 {
+		t.Fatalf("expected %v but found %v", 2, runs)
+	}
+
+	// queued jobs are dropped on ForceFinish
+	block = make(chan bool)
+	p.Add(func() { <-block })
+	p.Add(func() { t.Errorf("expected the queued job to be dropped") })
+	time.Sleep(10 * time.Millisecond)
+	p.ForceFinish()
+	close(block)
+	if !func() bool {
+		done := make(chan bool)
+		go func() {
+			p.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}() {
+		t.Fatalf("expected Wait to return after ForceFinish")
+	}
+	if p.TryAdd(func() {}) {
+		t.Fatalf("expected TryAdd to fail once finished")
+	}
+	if p.ForceFinished() {
+		t.Fatalf("expected %v once already finished", false)
+	}
+}
+
+func TestQueuePool_ForceFinished(t *testing.T) {
+	p := NewQueuePool(context.Background(), 2, 2)
+
+	var wins int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.ForceFinished() {
+				atomic.AddInt32(&wins, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("expected %v but found %v", 1, wins)
+	}
+	p.Close()
+}
+
+func TestQueuePool_WaitWhileAdding(t *testing.T) {
+	p := NewPersistent(context.Background(), 2)
+	q, ok := p.(*QueuePool)
+	if !ok {
+		t.Fatalf("expected a *QueuePool but found %T", p)
+	}
+	defer q.Close()
+
+	// Wait may see the count at zero between jobs, it must not panic or hang
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			p.Add(func() {})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		p.Wait()
+	}
+	<-done
+	p.Wait()
+}
+
+func TestQueuePool_NestedAddNoWait(t *testing.T) {
+	p := NewPersistent(context.Background(), 2)
+	defer p.(*QueuePool).Close()
+
+	// every worker runs a job adding more than the queue holds
+	var runs int32
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 4; i++ {
+			p.AddNoWait(func() {
+				for j := 0; j < 4; j++ {
+					p.AddNoWait(func() { atomic.AddInt32(&runs, 1) })
+				}
+			})
+		}
+		p.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected nested AddNoWait not to deadlock")
+	}
+	if runs != 16 {
+		t.Fatalf("expected %v but found %v", 16, runs)
+	}
+}
+
+func TestQueuePool_Persistent(t *testing.T) {
+	concur := 4
+	before := runtime.NumGoroutine()
+	p := NewPersistent(context.Background(), concur)
+	defer p.ForceFinish()
+
+	total := 1000
+	var runs int32
+	for i := 0; i < total; i++ {
+		if i%2 == 0 {
+			p.Add(func() { atomic.AddInt32(&runs, 1) })
+		} else {
+			p.AddNoWait(func() { atomic.AddInt32(&runs, 1) })
+		}
+		if n := runtime.NumGoroutine() - before; n > concur {
+			t.Fatalf("expected at most %v goroutines but found %v", concur, n)
+		}
+	}
+	p.Wait()
+	if runs != int32(total) {
+		t.Fatalf("expected %v but found %v", total, runs)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine()-before != concur && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine() - before; n != concur {
+		t.Fatalf("expected %v goroutines but found %v", concur, n)
+	}
+}
+
+func BenchmarkPool_PerJob(b *testing.B) {
+	p := full(New(context.Background(), runtime.NumCPU()))
+	for i := 0; i < b.N; i++ {
+		p.Add(func() {})
+	}
+	p.Wait()
+}
+
+func BenchmarkPool_Persistent(b *testing.B) {
+	p := NewPersistent(context.Background(), runtime.NumCPU())
+	defer p.ForceFinish()
+	for i := 0; i < b.N; i++ {
+		p.Add(func() {})
+	}
+	p.Wait()
+}
+
+func TestQueuePool_Close(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p := NewQueuePool(context.Background(), 2, 8)
+
+	var runs int32
+	for i := 0; i < 8; i++ {
+		p.Add(func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&runs, 1)
+		})
+	}
+	p.Close()
+	if runs != 8 {
+		t.Fatalf("expected %v but found %v", 8, runs)
+	}
+	// the workers may still be exiting once Close returns
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expected at most %v goroutines but found %v", before, n)
+	}
+
+	p.Add(func() { t.Errorf("expected the job to be dropped") })
+	p.AddNoWait(func() { t.Errorf("expected the job to be dropped") })
+	if p.TryAdd(func() {}) {
+		t.Fatalf("expected TryAdd to fail once closed")
+	}
+	p.Wait()
+	p.Close()
+}
+
+func TestQueuePool_WorkerStates(t *testing.T) {
+	concur := 3
+	pools := map[string]interface {
+		Pool
+		WorkerStates() []bool
+		Close() error
+	}{
+		"queue":    NewQueuePool(context.Background(), concur, concur),
+		"priority": NewPriorityPool(context.Background(), concur),
+	}
+
+	for name, p := range pools {
+		if states := p.WorkerStates(); len(states) != concur {
+			t.Fatalf("%v: expected %v but found %v", name, concur, len(states))
+		}
+
+		block := make(chan bool)
+		var started sync.WaitGroup
+		started.Add(concur)
+		for i := 0; i < concur; i++ {
+			p.Add(func() {
+				started.Done()
+				<-block
+			})
+		}
+		started.Wait()
+		for i, busy := range p.WorkerStates() {
+			if !busy {
+				t.Fatalf("%v: expected worker %v to be busy", name, i)
+			}
+		}
+
+		close(block)
+		p.Wait()
+		for i, busy := range p.WorkerStates() {
+			if busy {
+				t.Fatalf("%v: expected worker %v to be idle", name, i)
+			}
+		}
+		p.Close()
+	}
+}
@@ -0,0 +1,30 @@
+package threadpool
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// newLimiter returns the limiter for WithRateLimit(), or nil if job starts are not limited.
+func (c *config) newLimiter() *rate.Limiter {
+	if c.rateLimit <= 0 || c.rateLimit == rate.Inf {
+		return nil
+	}
+	return rate.NewLimiter(c.rateLimit, c.rateBurst)
+}
+
+// rateLimited returns f waiting on l before it runs. If ctx is done first f is not ran and the
+// job is counted by d as DropRateLimited. A nil l returns f as it is.
+func rateLimited(ctx context.Context, l *rate.Limiter, d *drops, f func()) func() {
+	if l == nil {
+		return f
+	}
+	return func() {
+		if l.Wait(ctx) != nil {
+			d.add(DropRateLimited)
+			return
+		}
+		f()
+	}
+}
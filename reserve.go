@@ -0,0 +1,99 @@
+package threadpool
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrReserveCapacity is returned by ReserveSlots() when asked for more threads than the pool has.
+var ErrReserveCapacity = errors.New("threadpool: cannot reserve more threads than the pool has")
+
+// ErrWeight is returned by AddWeighted() for a weight of less than 1.
+var ErrWeight = errors.New("threadpool: weight must be at least 1")
+
+// Reservation holds threads taken from a pool by ReserveSlots(). Jobs added to a Reservation
+// start immediately on one of its threads.
+type Reservation struct {
+	mux   sync.Mutex
+	pool  reservable
+	slots int
+}
+
+// reservable is implemented by pools that support ReserveSlots().
+type reservable interface {
+	// startReserved starts f on a thread already taken from the pool and returns true,
+	// or returns false if the pool will not run any more jobs.
+	startReserved(f func()) bool
+	// releaseThread returns a thread taken from the pool.
+	releaseThread()
+}
+
+// Add starts f on one of the reserved threads. It returns false without running f if all
+// reserved threads have been used or released, or if the pool will not run any more jobs.
+func (r *Reservation) Add(f func()) bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.slots == 0 || !r.pool.startReserved(f) {
+		return false
+	}
+	r.slots--
+	return true
+}
+
+// Release returns any reserved threads that have not been used back to the pool. It is safe
+// to call more than once.
+func (r *Reservation) Release() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	for ; r.slots > 0; r.slots-- {
+		r.pool.releaseThread()
+	}
+}
+
+// addGroup reserves a thread for each job in fs, starts them all and only then lets them run,
+// so they all begin at the same moment.
+func addGroup(p interface {
+	ReserveSlots(n int) (*Reservation, error)
+}, fs []func()) error {
+	r, err := p.ReserveSlots(len(fs))
+	if err != nil {
+		return err
+	}
+	defer r.Release()
+
+	gate := make(chan struct{})
+	defer close(gate)
+	for _, f := range fs {
+		f := f
+		r.Add(func() {
+			<-gate
+			f()
+		})
+	}
+	return nil
+}
+
+// addWeighted reserves weight threads, runs f on one of them and frees the rest once f
+// returns, so f counts as weight jobs against the pool's threads.
+func addWeighted(p interface {
+	ReserveSlots(n int) (*Reservation, error)
+}, f func(), weight int) error {
+	if weight <= 0 {
+		return ErrWeight
+	}
+	r, err := p.ReserveSlots(weight)
+	if err != nil {
+		return err
+	}
+
+	if !r.Add(func() {
+		defer r.Release()
+		f()
+	}) {
+		r.Release()
+		return ErrPoolClosed
+	}
+	return nil
+}
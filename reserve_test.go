@@ -0,0 +1,194 @@
+package threadpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool_ReserveSlots(t *testing.T) {
+	concur := 4
+	h := full(New(context.Background(), concur))
+
+	if _, err := h.ReserveSlots(concur + 1); err != ErrReserveCapacity {
+		t.Fatalf("expected %v but found %v", ErrReserveCapacity, err)
+	}
+
+	var reserved, interleaved int32
+	stop := make(chan bool)
+	competitor := sync.WaitGroup{}
+	competitor.Add(1)
+	go func() {
+		defer competitor.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.Add(func() {
+				if atomic.LoadInt32(&reserved) == 1 {
+					atomic.AddInt32(&interleaved, 1)
+				}
+				time.Sleep(time.Millisecond)
+			})
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	r, err := h.ReserveSlots(concur)
+	if err != nil {
+		t.Fatalf("expected no error but found %v", err)
+	}
+	atomic.StoreInt32(&reserved, 1)
+
+	var running int32
+	started := sync.WaitGroup{}
+	started.Add(concur)
+	gate := make(chan bool)
+	for i := 0; i < concur; i++ {
+		if !r.Add(func() {
+			atomic.AddInt32(&running, 1)
+			started.Done()
+			<-gate
+		}) {
+			t.Fatalf("expected job %v to be added", i)
+		}
+	}
+	if r.Add(func() {}) {
+		t.Fatalf("expected reservation to be used up")
+	}
+
+	started.Wait()
+	if running != int32(concur) {
+		t.Fatalf("expected %v but found %v", concur, running)
+	}
+	atomic.StoreInt32(&reserved, 0)
+	close(gate)
+	r.Release()
+
+	close(stop)
+	competitor.Wait()
+	h.Wait()
+
+	if interleaved != 0 {
+		t.Fatalf("expected %v but found %v", 0, interleaved)
+	}
+}
+
+func TestPool_ReserveSlotsRelease(t *testing.T) {
+	h := full(NewFixedSize(context.Background(), 2, 1))
+
+	r, err := h.ReserveSlots(2)
+	if err != nil {
+		t.Fatalf("expected no error but found %v", err)
+	}
+	r.Add(func() {})
+	r.Release()
+	r.Release()
+
+	if r.Add(func() {}) {
+		t.Fatalf("expected released reservation to reject jobs")
+	}
+	h.Wait()
+
+	r, err = h.ReserveSlots(2)
+	if err != nil {
+		t.Fatalf("expected released threads to be reservable but found %v", err)
+	}
+	r.Release()
+}
+
+func TestPool_AddGroup(t *testing.T) {
+	concur := 4
+	h := full(New(context.Background(), concur))
+
+	if err := h.AddGroup(make([]func(), concur+1)); err != ErrReserveCapacity {
+		t.Fatalf("expected %v but found %v", ErrReserveCapacity, err)
+	}
+
+	// keep the pool busy so the group has to wait for its threads
+	for i := 0; i < concur; i++ {
+		d := time.Duration(i+1) * 10 * time.Millisecond
+		h.AddNoWait(func() { time.Sleep(d) })
+	}
+
+	mux := sync.Mutex{}
+	var starts []time.Time
+	group := make([]func(), concur)
+	for i := range group {
+		group[i] = func() {
+			now := time.Now()
+			mux.Lock()
+			starts = append(starts, now)
+			mux.Unlock()
+		}
+	}
+	if err := h.AddGroup(group); err != nil {
+		t.Fatalf("expected no error but found %v", err)
+	}
+	h.Wait()
+
+	if len(starts) != concur {
+		t.Fatalf("expected %v but found %v", concur, len(starts))
+	}
+	first, last := starts[0], starts[0]
+	for _, s := range starts {
+		if s.Before(first) {
+			first = s
+		}
+		if s.After(last) {
+			last = s
+		}
+	}
+	if spread := last.Sub(first); spread > 5*time.Millisecond {
+		t.Fatalf("expected a near simultaneous start but found a spread of %v", spread)
+	}
+}
+
+func TestPool_AddWeighted(t *testing.T) {
+	concur := 4
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), concur, 20)),
+		"dynamic": full(New(context.Background(), concur)),
+	}
+	for name, h := range pools {
+		if err := h.AddWeighted(func() {}, 0); err != ErrWeight {
+			t.Fatalf("%v: expected %v but found %v", name, ErrWeight, err)
+		}
+		if err := h.AddWeighted(func() {}, concur+1); err != ErrReserveCapacity {
+			t.Fatalf("%v: expected %v but found %v", name, ErrReserveCapacity, err)
+		}
+
+		// heavy jobs take 3 of the 4 threads, so light jobs keep the total at 4
+		var load, peak int32
+		track := func(weight int32) func() {
+			return func() {
+				n := atomic.AddInt32(&load, weight)
+				defer atomic.AddInt32(&load, -weight)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(2 * time.Millisecond)
+			}
+		}
+		for i := 0; i < 10; i++ {
+			if err := h.AddWeighted(track(3), 3); err != nil {
+				t.Fatalf("%v: expected no error but found %v", name, err)
+			}
+			h.Add(track(1))
+		}
+		h.Barrier()()
+		if peak > int32(concur) {
+			t.Fatalf("%v: expected at most %v but found %v", name, concur, peak)
+		}
+		if s := h.Stats(); s.Available != concur {
+			t.Fatalf("%v: expected %v but found %v", name, concur, s.Available)
+		}
+	}
+}
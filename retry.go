@@ -0,0 +1,55 @@
+package threadpool
+
+import (
+	"context"
+	"time"
+)
+
+// retryIf returns a job that calls f up to attempts times, waiting backoff between calls, for
+// as long as f returns an error that retryable accepts. The wait is cut short if ctx is done,
+// in which case f is not called again. The job returns the error from the last call.
+func retryIf(ctx context.Context, attempts int, backoff time.Duration, retryable func(error) bool, f func() error) func() error {
+	return retry(ctx, attempts, func(int) time.Duration { return backoff }, retryable, f)
+}
+
+// retry is retryIf() waiting backoff(attempt) after the failed call numbered attempt,
+// counting from 1.
+func retry(ctx context.Context, attempts int, backoff func(attempt int) time.Duration, retryable func(error) bool, f func() error) func() error {
+	return func() error {
+		for attempt := 1; ; attempt++ {
+			err := f()
+			if err == nil || attempt >= attempts || !retryable(err) {
+				return err
+			}
+
+			t := time.NewTimer(backoff(attempt))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			}
+		}
+	}
+}
+
+// retryOnPanic returns a job that calls f, and calls it once more if it panics. A panic from
+// the second call is not recovered.
+func retryOnPanic(f func()) func() {
+	return func() {
+		if !completes(f) {
+			f()
+		}
+	}
+}
+
+// completes calls f and returns true if it returned without panicking.
+func completes(f func()) (ok bool) {
+	defer func() {
+		if !ok {
+			recover()
+		}
+	}()
+	f()
+	return true
+}
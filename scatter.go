@@ -0,0 +1,24 @@
+package threadpool
+
+import "context"
+
+// Scatter runs every function in fs with in as its argument and returns the results in the
+// same order as fs. The functions run concurrently on a pool of runtime.NumCPU() threads.
+//
+// If ctx is cancelled functions that have not started are skipped and their results are
+// left as the zero value of R.
+func Scatter[T, R any](ctx context.Context, in T, fs []func(T) R) []R {
+	results := make([]R, len(fs))
+
+	p := New(ctx, 0)
+	defer p.ForceFinish()
+	for i, f := range fs {
+		i, f := i, f
+		p.Add(func() {
+			results[i] = f(in)
+		})
+	}
+	p.Wait()
+
+	return results
+}
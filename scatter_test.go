@@ -0,0 +1,74 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScatter(t *testing.T) {
+	fs := []func(string) string{
+		func(s string) string {
+			time.Sleep(30 * time.Millisecond)
+			return strings.ToUpper(s)
+		},
+		func(s string) string {
+			time.Sleep(20 * time.Millisecond)
+			return strings.Repeat(s, 2)
+		},
+		func(s string) string {
+			return s + "!"
+		},
+	}
+
+	actual := Scatter(context.Background(), "go", fs)
+
+	expected := []string{"GO", "gogo", "go!"}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %v but found %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("expected %v but found %v", expected, actual)
+		}
+	}
+}
+
+// watchedCtx is a context the context package cannot see inside of, so each context derived
+// from it is watched by a goroutine until the derived context is cancelled. Counting
+// goroutines then shows if derived contexts are left behind.
+type watchedCtx struct {
+	context.Context
+	done chan struct{}
+}
+
+func (c watchedCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+// expectNoLeak fails t if calling f many times with a watchedCtx leaves goroutines behind.
+func expectNoLeak(t *testing.T, f func(ctx context.Context)) {
+	ctx := watchedCtx{Context: context.Background(), done: make(chan struct{})}
+	defer close(ctx.done)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		f(ctx)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expected at most %v goroutines but found %v", before, n)
+	}
+}
+
+func TestScatter_NoLeak(t *testing.T) {
+	fs := []func(int) int{func(i int) int { return i }}
+	expectNoLeak(t, func(ctx context.Context) {
+		Scatter(ctx, 1, fs)
+	})
+}
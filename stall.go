@@ -0,0 +1,76 @@
+package threadpool
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// stallWatch calls onStall for each job still running after the duration given to
+// WithStallWarning(). Jobs are given ids in the order they are wrapped by watch().
+type stallWatch struct {
+	after   time.Duration
+	onStall func(jobID uint64, label string, running time.Duration)
+	ids     uint64
+}
+
+// watch returns f wrapped to call onStall once if it runs longer than s.after. f is returned
+// as is when no warning is wanted.
+func (s *stallWatch) watch(f func(), label string) func() {
+	if s.after <= 0 || s.onStall == nil {
+		return f
+	}
+
+	id := atomic.AddUint64(&s.ids, 1)
+	return func() {
+		start := time.Now()
+		t := time.AfterFunc(s.after, func() { s.onStall(id, label, time.Since(start)) })
+		defer t.Stop()
+		f()
+	}
+}
+
+// stallDetector warns when jobs are outstanding but no thread has been freed for the duration
+// given to WithStallDetector(), as happens when every thread is held by a job that never
+// returns.
+type stallDetector struct {
+	freed int64 // unix nanoseconds of the last thread freed
+}
+
+// threadFreed records that a thread was freed.
+func (s *stallDetector) threadFreed() {
+	atomic.StoreInt64(&s.freed, time.Now().UnixNano())
+}
+
+// run checks every quarter of d, until ctx is done, for jobs outstanding without a thread
+// freed for longer than d. It logs once for each stall.
+func (s *stallDetector) run(ctx context.Context, d time.Duration, active func() bool) {
+	t := time.NewTicker(d / 4)
+	defer t.Stop()
+
+	since := time.Now().UnixNano() // the jobs have been outstanding since
+	warned := false
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		now := time.Now().UnixNano()
+		if freed := atomic.LoadInt64(&s.freed); freed > since {
+			since = freed
+			warned = false
+		}
+		if !active() {
+			since = now
+			warned = false
+			continue
+		}
+		if stalled := time.Duration(now - since); !warned && stalled > d {
+			log.Printf("threadpool: no thread freed for %v while jobs are outstanding, a job may be stuck", stalled.Round(time.Millisecond))
+			warned = true
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// StartWorkers starts n workers that each call f with the items read from jobs until jobs is
+// closed, and returns a WaitGroup to Wait() on for the workers to finish. Each item is given
+// to exactly one worker.
+//
+// If n is <=0 it will assume runtime.NumCPU(). If ctx is done the workers stop once their
+// current item is done, leaving any remaining items unread. A nil ctx is treated as
+// context.Background().
+func StartWorkers[T any](ctx context.Context, n int, jobs <-chan T, f func(T)) *sync.WaitGroup {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				// a done context takes priority over a ready item
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case item, ok := <-jobs:
+					if !ok {
+						return
+					}
+					f(item)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return wg
+}
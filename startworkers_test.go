@@ -0,0 +1,53 @@
+package threadpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartWorkers(t *testing.T) {
+	total := 100
+	jobs := make(chan int)
+
+	var mux sync.Mutex
+	seen := make(map[int]int)
+	wg := StartWorkers(context.Background(), 4, jobs, func(i int) {
+		mux.Lock()
+		seen[i]++
+		mux.Unlock()
+	})
+	for i := 0; i < total; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(seen) != total {
+		t.Fatalf("expected %v but found %v", total, len(seen))
+	}
+	for i, n := range seen {
+		if n != 1 {
+			t.Fatalf("expected item %v once but found %v", i, n)
+		}
+	}
+}
+
+func TestStartWorkers_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := make(chan int)
+	wg := StartWorkers(ctx, 2, jobs, func(int) {})
+	cancel()
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the workers to stop without jobs being closed")
+	}
+}
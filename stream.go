@@ -0,0 +1,186 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// OrderedStream runs jobs concurrently and delivers their results in the order the jobs were
+// added, like an ordered asynchronous map.
+//
+//	At most lookahead jobs are running or holding a result that is waiting on earlier
+//	results, so a slow job or a slow reader of Results() holds back Add().
+type OrderedStream[T any] struct {
+	mux      sync.Mutex
+	ctx      context.Context
+	pool     Pool
+	finished <-chan struct{} // closed once pool will not run any more jobs
+	closed   bool
+	window   chan struct{}
+	pending  chan chan T
+	results  chan T
+}
+
+// StreamOption configures an OrderedStream.
+type StreamOption func(*streamConfig)
+
+type streamConfig struct {
+	lookahead int
+	pool      Pool
+}
+
+// WithLookahead sets how many jobs an OrderedStream runs ahead of the next result to be
+// delivered. If k is <=0 it will assume runtime.NumCPU(), which is also the default.
+func WithLookahead(k int) StreamOption {
+	return func(c *streamConfig) {
+		c.lookahead = k
+	}
+}
+
+// WithStreamPool runs the jobs of an OrderedStream on p, so they are limited by p's threads as
+// well as the lookahead, rather than each on a goroutine of its own.
+func WithStreamPool(p Pool) StreamOption {
+	return func(c *streamConfig) {
+		c.pool = p
+	}
+}
+
+// NewOrderedStream creates an OrderedStream. Close() must be called once all jobs are added
+// for Results() to be closed. If ctx is done no more jobs are added and Results() is closed
+// without waiting on the remaining results. A nil ctx is treated as context.Background().
+func NewOrderedStream[T any](ctx context.Context, opts ...StreamOption) *OrderedStream[T] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cfg streamConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.lookahead <= 0 {
+		cfg.lookahead = runtime.NumCPU()
+	}
+
+	s := &OrderedStream[T]{
+		ctx:     ctx,
+		window:  make(chan struct{}, cfg.lookahead),
+		pending: make(chan chan T, cfg.lookahead),
+		results: make(chan T),
+		pool:    cfg.pool,
+	}
+	if pd, ok := cfg.pool.(poolDone); ok {
+		s.finished = pd.finished()
+	}
+	go s.deliver()
+	return s
+}
+
+// Add runs f once it is within the lookahead of the next result to be delivered, blocking
+// until then. It returns false without running f if the stream is closed or ctx is done, or
+// if the pool from WithStreamPool() will not run any more jobs. If f panics on the pool it
+// has no result and is skipped.
+func (s *OrderedStream[T]) Add(f func() T) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.closed {
+		return false
+	}
+	select {
+	case s.window <- struct{}{}:
+	case <-s.ctx.Done():
+		return false
+	}
+
+	c := make(chan T, 1)
+	if s.pool == nil {
+		go func() {
+			c <- f()
+		}()
+	} else if addOrErr(s.pool, func() {
+		// closing c without a result skips a job that panicked
+		defer close(c)
+		c <- f()
+	}) != nil {
+		<-s.window
+		return false
+	}
+	s.pending <- c
+	return true
+}
+
+// Results returns the channel the results are delivered on in the order the jobs were added.
+func (s *OrderedStream[T]) Results() <-chan T {
+	return s.results
+}
+
+// Close stops any more jobs from being added. Results() is closed once the results of the
+// jobs already added are delivered. It is safe to call more than once.
+func (s *OrderedStream[T]) Close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.pending)
+	}
+}
+
+func (s *OrderedStream[T]) deliver() {
+	defer close(s.results)
+
+	for {
+		var c chan T
+		select {
+		case next, ok := <-s.pending:
+			if !ok {
+				return
+			}
+			c = next
+		case <-s.ctx.Done():
+			return
+		}
+
+		var v T
+		var ok bool
+		select {
+		case v, ok = <-c:
+		case <-s.ctx.Done():
+			return
+		case <-s.finished:
+			// the pool may have finished after running the job
+			select {
+			case v, ok = <-c:
+			default:
+				return
+			}
+		}
+		if !ok {
+			<-s.window
+			continue
+		}
+		select {
+		case s.results <- v:
+		case <-s.ctx.Done():
+			return
+		}
+		<-s.window
+	}
+}
+
+// Stream runs the jobs given to emit by gen on p and returns a channel of their results in
+// the order they were emitted, although they complete out of order. The channel is closed
+// once gen has returned and all the results are delivered, or early if p is finished or ctx
+// is done. A nil ctx is treated as context.Background().
+//
+//	emit blocks while runtime.NumCPU() jobs are running or waiting on earlier results, so
+//	the results must be read for gen to carry on. emit returns false if the job was not
+//	added, as once p or ctx is done, so gen can stop rather than leave a gap unnoticed.
+func Stream[T any](ctx context.Context, p Pool, gen func(emit func(func() T) bool)) <-chan T {
+	s := NewOrderedStream[T](ctx, WithStreamPool(p))
+	go func() {
+		defer s.Close()
+		gen(s.Add)
+	}()
+	return s.Results()
+}
@@ -0,0 +1,180 @@
+package threadpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderedStream(t *testing.T) {
+	total := 30
+	lookahead := 4
+	s := NewOrderedStream[int](context.Background(), WithLookahead(lookahead))
+
+	var running, peak int32
+	go func() {
+		defer s.Close()
+		for i := 0; i < total; i++ {
+			i := i
+			s.Add(func() int {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				// later jobs tend to finish first
+				time.Sleep(time.Duration((i*7)%5) * time.Millisecond)
+				return i
+			})
+		}
+	}()
+
+	expected := 0
+	for v := range s.Results() {
+		if v != expected {
+			t.Fatalf("expected %v but found %v", expected, v)
+		}
+		expected++
+	}
+	if expected != total {
+		t.Fatalf("expected %v but found %v", total, expected)
+	}
+	if peak > int32(lookahead) {
+		t.Fatalf("expected at most %v running but found %v", lookahead, peak)
+	}
+	if s.Add(func() int { return 0 }) {
+		t.Fatalf("expected Add to fail after Close")
+	}
+}
+
+func TestOrderedStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewOrderedStream[int](ctx, WithLookahead(1))
+
+	s.Add(func() int { return 1 })
+	added := make(chan bool)
+	go func() {
+		added <- s.Add(func() int { return 2 })
+	}()
+
+	cancel()
+	if <-added {
+		t.Fatalf("expected Add to fail once cancelled")
+	}
+	for range s.Results() {
+	}
+}
+
+func TestStream(t *testing.T) {
+	total := 30
+	h := New(context.Background(), 3)
+	results := Stream(context.Background(), h, func(emit func(func() int) bool) {
+		for i := 0; i < total; i++ {
+			i := i
+			emit(func() int {
+				time.Sleep(time.Duration((i*7)%5) * time.Millisecond)
+				return i
+			})
+		}
+	})
+
+	expected := 0
+	for v := range results {
+		if v != expected {
+			t.Fatalf("expected %v but found %v", expected, v)
+		}
+		expected++
+	}
+	if expected != total {
+		t.Fatalf("expected %v but found %v", total, expected)
+	}
+}
+
+func TestStream_PoolFinished(t *testing.T) {
+	h := New(context.Background(), 1)
+	block := make(chan bool)
+	results := Stream(context.Background(), h, func(emit func(func() int) bool) {
+		emit(func() int { return 1 })
+		emit(func() int {
+			<-block
+			return 2
+		})
+		if emit(func() int { return 3 }) {
+			t.Errorf("expected emit to fail once the pool finished")
+		}
+	})
+
+	if v := <-results; v != 1 {
+		t.Fatalf("expected %v but found %v", 1, v)
+	}
+	h.ForceFinish()
+	close(block)
+	for v := range results {
+		if v == 3 {
+			t.Fatalf("expected the job after ForceFinish not to run")
+		}
+	}
+}
+
+func TestStream_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := New(context.Background(), 2)
+	dropped := make(chan bool)
+	results := Stream(ctx, h, func(emit func(func() int) bool) {
+		for i := 0; ; i++ {
+			i := i
+			if !emit(func() int { return i }) {
+				close(dropped)
+				return
+			}
+		}
+	})
+
+	if v := <-results; v != 0 {
+		t.Fatalf("expected %v but found %v", 0, v)
+	}
+	cancel()
+	select {
+	case <-dropped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected emit to fail once ctx was done")
+	}
+	for range results {
+	}
+}
+
+func TestStream_Panic(t *testing.T) {
+	h := New(context.Background(), 2, WithPanicHandler(func(any, []byte) {}))
+	results := Stream(context.Background(), h, func(emit func(func() int) bool) {
+		for i := 0; i < 4; i++ {
+			i := i
+			emit(func() int {
+				if i == 1 {
+					panic("boom")
+				}
+				return i
+			})
+		}
+	})
+
+	var actual []int
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for v := range results {
+			actual = append(actual, v)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the results to close after a job panicked")
+	}
+	if len(actual) != 3 || actual[0] != 0 || actual[1] != 2 || actual[2] != 3 {
+		t.Fatalf("expected %v but found %v", []int{0, 2, 3}, actual)
+	}
+}
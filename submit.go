@@ -0,0 +1,39 @@
+package threadpool
+
+import "sync/atomic"
+
+// Submit adds f to p like AddNoWait() and returns a channel that receives f's result once it
+// has ran. The channel is closed after the result, or without one if f will never run
+// because p was finished first, with ForceFinish() for instance, or if f panicked and the
+// panic was recovered.
+//
+//	Jobs dropped by p for other reasons, such as being past totalJobs for NewFixedSize(),
+//	are only closed once p is finished.
+func Submit[T any](p Pool, f func() T) <-chan T {
+	c := make(chan T, 1)
+
+	// the job and the pool finishing race to claim c, so it is closed exactly once
+	var claimed int32
+	started := make(chan struct{})
+	p.AddNoWait(func() {
+		if !atomic.CompareAndSwapInt32(&claimed, 0, 1) {
+			return
+		}
+		close(started)
+		defer close(c)
+		c <- f()
+	})
+
+	if pd, ok := p.(poolDone); ok {
+		go func() {
+			select {
+			case <-started:
+			case <-pd.finished():
+				if atomic.CompareAndSwapInt32(&claimed, 0, 2) {
+					close(c)
+				}
+			}
+		}()
+	}
+	return c
+}
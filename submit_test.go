@@ -0,0 +1,52 @@
+package threadpool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubmit(t *testing.T) {
+	h := New(context.Background(), 2)
+
+	var results []<-chan int
+	for i := 0; i < 10; i++ {
+		i := i
+		results = append(results, Submit(h, func() int {
+			time.Sleep(time.Duration(10-i) * time.Millisecond)
+			return i * i
+		}))
+	}
+	for i, c := range results {
+		if v, ok := <-c; !ok || v != i*i {
+			t.Fatalf("expected %v but found %v (%v)", i*i, v, ok)
+		}
+		if _, ok := <-c; ok {
+			t.Fatalf("expected the channel to be closed after the result")
+		}
+	}
+	h.Wait()
+}
+
+func TestSubmit_ForceFinish(t *testing.T) {
+	h := New(context.Background(), 1)
+	block := make(chan bool)
+	h.Add(func() { <-block })
+
+	c := Submit(h, func() string {
+		t.Errorf("expected the job not to run")
+		return "ran"
+	})
+	h.ForceFinish()
+
+	select {
+	case v, ok := <-c:
+		if ok {
+			t.Fatalf("expected the channel to be closed but found %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to be closed")
+	}
+	close(block)
+	h.Wait()
+}
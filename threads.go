@@ -1,16 +1,130 @@
 package threadpool
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"log"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
+// Pool runs jobs on a limited number of threads. The pools from New() and NewFixedSize() have
+// more methods than these, grouped into interfaces such as Adder, Waiter and Inspector.
 type Pool interface {
 	Add(f func())
 	AddNoWait(f func())
 	Wait()
 	ForceFinish()
+	IsDone() bool
+	Kind() PoolKind
+	Concurrency() int
+	Total() int
+}
+
+// ErrMaxLifetime is returned by Err() once a pool has been running longer than the
+// duration given to WithMaxLifetime().
+var ErrMaxLifetime = errors.New("threadpool: pool reached its max lifetime")
+
+// ErrPoolClosed is returned by AddOrErr() when the pool will not run any more jobs, and by
+// Err() once the pool is closed by Close().
+var ErrPoolClosed = errors.New("threadpool: pool is closed")
+
+// ErrReopenFixed is returned by Reopen() on a pool created by NewFixedSize().
+var ErrReopenFixed = errors.New("threadpool: fixed size pool cannot be reopened")
+
+// ErrJobsInFlight is returned by Reopen() and Reset() while the pool still has jobs added or
+// running, or threads taken by Acquire() or ReserveSlots().
+var ErrJobsInFlight = errors.New("threadpool: pool has jobs in flight")
+
+// ErrMaxDepth is returned by AddOrErr() for a job added from within jobs nested deeper than
+// WithMaxDepth() allows.
+var ErrMaxDepth = errors.New("threadpool: job exceeds max depth")
+
+// Stats is a snapshot of what a pool is doing, from Stats().
+type Stats struct {
+	// Running is the number of threads in use, concurrentThreads less Available.
+	Running int
+	// Available is the number of free threads.
+	Available int
+	// Completed is the number of jobs that have returned, including those that panicked.
+	Completed int64
+	// Panicked is the number of jobs whose panic was recovered. Unlike PanickedJobs() it is
+	// not reset when read.
+	Panicked int64
+	// Pending is the number of jobs left of totalJobs for NewFixedSize(), and 0 for New().
+	Pending int
+	// Waiting is the number of added jobs waiting on a free thread.
+	Waiting int
+	// WaitCount is the number of jobs that have waited on a free thread, whether they had to
+	// block or one was free at once.
+	WaitCount int64
+	// TotalWaitTime is the time all the jobs counted by WaitCount spent waiting on a free
+	// thread. A total growing much faster than WaitCount means the threads are the bottleneck.
+	TotalWaitTime time.Duration
+}
+
+// ErrResize is returned by Resize() for a number of threads the pool cannot have.
+var ErrResize = errors.New("threadpool: threads must be at least 1")
+
+// ErrBatchSize is returned by AddBatch() on a fixed size pool when fewer of totalJobs are
+// left than there are jobs in the batch.
+var ErrBatchSize = errors.New("threadpool: batch is larger than the jobs left of totalJobs")
+
+// PoolKind identifies which constructor created a Pool.
+type PoolKind int
+
+const (
+	// Fixed is a pool created by NewFixedSize().
+	Fixed PoolKind = iota
+	// Dynamic is a pool created by New().
+	Dynamic
+	// Queue is a QueuePool, created by NewQueuePool() or NewPersistent().
+	Queue
+	// Priority is a PriorityPool, created by NewPriorityPool().
+	Priority
+)
+
+func (k PoolKind) String() string {
+	switch k {
+	case Fixed:
+		return "Fixed"
+	case Dynamic:
+		return "Dynamic"
+	case Queue:
+		return "Queue"
+	case Priority:
+		return "Priority"
+	default:
+		return "PoolKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// describe returns the String() of a pool, such as
+// "threadpool[name=ingest kind=Fixed concurrency=8 running=3 waiting=120 pending=5]". The name
+// is left out if empty and pending, the jobs left of totalJobs, is only given for Fixed.
+func describe(name string, kind PoolKind, concurrency int, s Stats) string {
+	var b strings.Builder
+	b.WriteString("threadpool[")
+	if name != "" {
+		b.WriteString("name=" + name + " ")
+	}
+	b.WriteString("kind=" + kind.String())
+	b.WriteString(" concurrency=" + strconv.Itoa(concurrency))
+	b.WriteString(" running=" + strconv.Itoa(s.Running))
+	b.WriteString(" waiting=" + strconv.Itoa(s.Waiting))
+	if kind == Fixed {
+		b.WriteString(" pending=" + strconv.Itoa(s.Pending))
+	}
+	b.WriteString("]")
+	return b.String()
 }
 
 // NewFixedSize creates a thread pool with concurrentThreads and totalJobs.
@@ -19,213 +133,2701 @@ type Pool interface {
 //	full/finished and no more job will be allowed for this instance.
 //	Additionally, Wait() will wait until all totalJobs Add() or AddNoWait()
 //	have completed. So make sure to Add()/AddNoWait() totalJobs or it will wait
-//	forever.
+//	forever, unless the context is done first or CommitRemaining() gives up the rest.
 //
-// If concurrentThreads is <=0 it will assume runtime.NumCPU().
-func NewFixedSize(ctx context.Context, concurrentThreads, totalJobs int) Pool {
-	if concurrentThreads <= 0 {
-		concurrentThreads = runtime.NumCPU()
+// If concurrentThreads is <=0 it will assume runtime.NumCPU(). If totalJobs is <0 it will
+// assume 0, so the pool is full from the start and Wait() returns at once. A nil ctx is
+// treated as context.Background(). concurrentThreads and totalJobs take the place of any
+// WithConcurrency() or WithTotal() in opts.
+func NewFixedSize(ctx context.Context, concurrentThreads, totalJobs int, opts ...Option) Pool {
+	return NewFixedSizeWithOptions(ctx, append(opts[:len(opts):len(opts)], WithConcurrency(concurrentThreads), WithTotal(totalJobs))...)
+}
+
+// NewFixedSizeWithOptions is NewFixedSize() taking concurrentThreads from WithConcurrency()
+// and totalJobs from WithTotal(), so the whole configuration is given as options. Without
+// WithTotal() totalJobs is 0.
+func NewFixedSizeWithOptions(ctx context.Context, opts ...Option) Pool {
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	c := make(chan bool, concurrentThreads)
-	for i := 0; i < concurrentThreads; i++ {
-		c <- true
+	cfg := newConfig(opts)
+	cfg.kind = Fixed
+	if cfg.concurrency <= 0 {
+		cfg.concurrency = runtime.NumCPU()
+	}
+	if cfg.total < 0 {
+		cfg.total = 0
 	}
+	return newFixedPool(ctx, cfg)
+}
 
-	cCtx, can := context.WithCancel(ctx)
+func newFixedPool(ctx context.Context, cfg config) *fixedPool {
 	p := fixedPool{
-		size:      totalJobs,
-		mux:       sync.Mutex{},
-		ctx:       cCtx,
-		ctxCancel: can,
-		c:         c,
-		wg:        sync.WaitGroup{},
+		cfg:    cfg,
+		size:   cfg.total,
+		mux:    sync.Mutex{},
+		parent: ctx,
 	}
-	p.wg.Add(totalJobs)
+	p.panics.store(p.cfg.panicHandler)
+	p.pause.size = p.cfg.pauseBuffer
+	p.latency.rand = p.cfg.rand
+	p.stalls.after = p.cfg.stallAfter
+	p.stalls.onStall = p.cfg.onStall
+	p.workers.tracked = p.cfg.nestedJobs || p.cfg.maxDepth > 0
+	p.limiter = p.cfg.newLimiter()
+	p.wg.Add(cfg.total)
+	p.open()
 
 	return &p
 }
 
+// open gives the pool a new context from its parent and a new set of threads.
+func (p *fixedPool) open() {
+	cCtx, can := context.WithCancelCause(p.parent)
+	c := make(chan bool, p.cfg.concurrency)
+	var lifetime *time.Timer
+	if p.cfg.maxLifetime > 0 {
+		lifetime = time.AfterFunc(p.cfg.maxLifetime, func() { can(ErrMaxLifetime) })
+	}
+	// the state is in place before any thread is given back to it
+	p.stateMux.Lock()
+	if old := p.state.Load(); old != nil && old.lifetime != nil {
+		old.lifetime.Stop()
+	}
+	p.state.Store(&poolState{ctx: cCtx, cancel: can, c: c, replaced: make(chan struct{}), lifetime: lifetime})
+	p.stateMux.Unlock()
+	atomic.StoreInt64(&p.limit, int64(p.cfg.concurrency))
+	atomic.StoreInt64(&p.owed, 0)
+	atomic.StoreInt32(&p.draining, 0)
+
+	giveBack := func() { p.giveBackFrom(cCtx) }
+	fillThreads(cCtx, c, p.cfg.rampUp, giveBack)
+	if p.cfg.gcBackoff {
+		go backoffOnGC(cCtx, p.threads, giveBack, gcCycles, gcBackoffInterval)
+	}
+	if p.cfg.stallDetect > 0 {
+		go p.stuck.run(cCtx, p.cfg.stallDetect, p.jobs.active)
+	}
+	// however the context ends the jobs not yet added will never run, unless Reset() has
+	// given the pool a new context by then
+	context.AfterFunc(cCtx, func() {
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		if p.state.Load().ctx == cCtx {
+			p.zeroize()
+		}
+	})
+}
+
+// ctx returns the pool's context, which Reset() and Reopen() replace.
+func (p *fixedPool) ctx() context.Context {
+	return p.state.Load().ctx
+}
+
+// cancel finishes the pool's context with cause, stopping the timer of WithMaxLifetime().
+func (p *fixedPool) cancel(cause error) {
+	st := p.state.Load()
+	if st.lifetime != nil {
+		st.lifetime.Stop()
+	}
+	st.cancel(cause)
+}
+
+// threads returns the channel holding the pool's free threads.
+func (p *fixedPool) threads() chan bool {
+	return p.state.Load().c
+}
+
+// poolState is what open() gives a pool, its context and the channel holding its free
+// threads. It is replaced whole, so it can be read without p.mux while Reset() or Reopen()
+// give the pool a new one, or Resize() grows the pool past the room in c.
+type poolState struct {
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	c        chan bool
+	replaced chan struct{} // closed once Resize() replaces c with a larger channel
+	lifetime *time.Timer   // from WithMaxLifetime(), nil without it
+}
+
 type fixedPool struct {
-	size      int
-	mux       sync.Mutex
-	ctx       context.Context
-	ctxCancel context.CancelFunc
-	c         chan bool
-	wg        sync.WaitGroup
+	cfg        config
+	size       int
+	mux        sync.Mutex
+	reserveMux sync.Mutex
+	parent     context.Context
+	state      atomic.Pointer[poolState]
+	stateMux   sync.RWMutex // held for writing while the threads are replaced
+	wg         counter
+	once       onceKeys
+	jobs       tracker
+	leaked     int64
+	spawned    int64
+	completed  completions
+	waiting    int64
+	waitCount  int64
+	waitNanos  int64
+	limit      int64
+	owed       int64
+	acquired   int64
+	reserved   int64 // threads held by a Reservation and not yet used
+	draining   int32
+	panics     panicHandler
+	latency    reservoir
+	stalls     stallWatch
+	drops      drops
+	limiter    *rate.Limiter
+	stuck      stallDetector
+	errs       jobErrors
+	pause      pauser
+	workers    workers
 }
 
 // Add adds a new job to be ran. When called it will blocks until a free thread can work on the job.
+//
+//	Called from one of the pool's own jobs it waits like any other caller, so jobs adding
+//	to their own pool can deadlock with every thread held by a job waiting on a free
+//	thread. With WithNestedJobs() such a job is ran inline instead of waiting.
 func (p *fixedPool) Add(f func()) {
+	p.add(f, "")
+}
+
+// AddIndexed adds a new job like Add() that calls f with i, such as the index of the item it
+// is to work on. i is passed by value when AddIndexed() is called, so giving it a loop
+// variable is safe even where each iteration does not have its own copy, as before Go 1.22.
+func (p *fixedPool) AddIndexed(i int, f func(int)) {
+	p.Add(func() { f(i) })
+}
+
+// AddBatch adds every job in fs like Add(), in order, blocking until each has a free thread.
+//
+//	Before adding any it checks enough of totalJobs are left for the whole batch, and
+//	returns ErrBatchSize without adding any job otherwise, so a batch is never half
+//	added. ErrPoolClosed is returned if the pool's context is already done.
+func (p *fixedPool) AddBatch(fs []func()) error {
+	if p.ctx().Err() != nil {
+		return ErrPoolClosed
+	}
+
+	p.mux.Lock()
+	if p.size < len(fs) {
+		p.mux.Unlock()
+		return ErrBatchSize
+	}
+	p.size -= len(fs)
+	p.mux.Unlock()
+
+	for _, f := range fs {
+		p.addClaimed(f, "")
+	}
+	return nil
+}
+
+// AddAfter adds a new job like AddNoWait() that only starts waiting on a free thread once d
+// has passed, such as to stagger retries. It does not block. The job counts towards
+// totalJobs straight away, and if the pool is finished before d has passed it is dropped.
+func (p *fixedPool) AddAfter(d time.Duration, f func()) {
 	p.mux.Lock()
 	if p.size == 0 {
 		p.mux.Unlock()
 		return
 	}
-
 	p.size--
 	p.mux.Unlock()
+
+	go p.after(p.ctx(), d, f, p.jobs.join())
+}
+
+// after adds f, already taken from p.size and joined to epoch e, once d has passed.
+func (p *fixedPool) after(ctx context.Context, d time.Duration, f func(), e *epoch) {
+	t := time.NewTimer(d)
 	select {
-	case <-p.c:
-	case <-p.ctx.Done():
+	case <-t.C:
+		p.addJoined(f, "", e)
+	case <-ctx.Done():
+		t.Stop()
+		p.jobs.leave(e)
+		p.wg.Done()
+	}
+}
+
+// AddOrErr adds a new job like Add(), blocking until a free thread can work on the job. If
+// the pool's context is done, before or while waiting, ErrPoolClosed is returned and the
+// job is not ran.
+//
+//	ErrPoolClosed is also returned once totalJobs have been added, and ErrMaxDepth for
+//	a job nested deeper than WithMaxDepth() allows.
+func (p *fixedPool) AddOrErr(f func()) error {
+	if p.ctx().Err() != nil {
+		return ErrPoolClosed
+	}
+	if _, ok := p.depth(); !ok {
+		p.tooDeep()
+		return ErrMaxDepth
+	}
+	if !p.add(f, "") {
+		return ErrPoolClosed
+	}
+	return nil
+}
+
+// add is Add() for a job with label, returning false if the job will not be ran.
+func (p *fixedPool) add(f func(), label string) bool {
+	if _, ok := p.depth(); !ok {
+		p.tooDeep()
+		return false
+	}
+	if p.pause.buffer() {
+		p.addNoWait(f, label, nil)
+		return true
+	}
+
+	p.mux.Lock()
+	if p.size == 0 {
+		p.mux.Unlock()
+		return false
+	}
+
+	p.size--
+	p.mux.Unlock()
+	return p.addClaimed(f, label)
+}
+
+// addClaimed is add() for a job already taken from p.size.
+func (p *fixedPool) addClaimed(f func(), label string) bool {
+	return p.addJoined(f, label, p.jobs.join())
+}
+
+// addJoined is addClaimed() for a job already joined to epoch e.
+func (p *fixedPool) addJoined(f func(), label string, e *epoch) bool {
+	submitted := time.Now()
+	if p.nested() {
+		p.job(f, label)()
+		p.jobs.leave(e)
+		p.wg.Done()
+		return true
+	}
+	if !p.acquireJob() {
 		// we zeroize the waitgroup
 		p.zeroizeWaitgroup()
-		return
-	}
-	go func() {
-		f()
-		p.c <- true
+		p.jobs.leave(e)
 		p.wg.Done()
-	}()
+		return false
+	}
+	p.latency.add(time.Since(submitted))
+	atomic.AddInt64(&p.spawned, 1)
+	go runJob(p.job(f, label), func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	return true
+}
+
+// acquireJob is acquire() for a job, counting it as waiting until it returns and adding the
+// time it took to the wait stats.
+func (p *fixedPool) acquireJob() bool {
+	atomic.AddInt64(&p.waiting, 1)
+	start := time.Now()
+	ok := p.acquire()
+	atomic.AddInt64(&p.waitCount, 1)
+	atomic.AddInt64(&p.waitNanos, int64(time.Since(start)))
+	atomic.AddInt64(&p.waiting, -1)
+	p.completed.changed()
+	return ok
+}
+
+// acquire blocks until a thread is free and returns true, or returns false if the
+// context is done first.
+func (p *fixedPool) acquire() bool {
+	if resumed := p.pause.wait(); resumed != nil {
+		select {
+		case <-resumed:
+		case <-p.ctx().Done():
+			return false
+		}
+	}
+
+	if takeThread(&p.state, context.Background()) != nil {
+		return false
+	}
+	// when both were ready the context takes priority
+	if p.ctx().Err() != nil {
+		p.giveBack()
+		return false
+	}
+	return true
+}
+
+// job returns f with label wrapped to be ran by the pool. It must be called from the
+// goroutine adding the job so the job's depth is known.
+func (p *fixedPool) job(f func(), label string) func() {
+	depth, _ := p.depth()
+	guarded := p.panics.guard(p.ctx(), p.stalls.watch(f, label))
+	return p.workers.run(rateLimited(p.ctx(), p.limiter, &p.drops, func() {
+		guarded()
+		n := p.completed.add()
+		if p.cfg.progress != nil {
+			p.cfg.progress(int(n), p.Total())
+		}
+	}), depth)
+}
+
+// depth returns the depth of a job added from the calling goroutine, 1 unless added from
+// within a job, and false if that is deeper than WithMaxDepth() allows. Without
+// WithMaxDepth() the depth is not tracked and is always 1.
+func (p *fixedPool) depth() (int, bool) {
+	if p.cfg.maxDepth <= 0 {
+		return 1, true
+	}
+	d := p.workers.depth() + 1
+	return d, d <= p.cfg.maxDepth
+}
+
+// nested returns true if called from one of the pool's jobs while no thread is free.
+func (p *fixedPool) nested() bool {
+	return len(p.threads()) == 0 && p.ctx().Err() == nil && p.workers.current()
+}
+
+// done returns the thread used by a job of epoch e and marks the job as completed.
+func (p *fixedPool) done(e *epoch) {
+	p.giveBack()
+	p.completed.changed()
+	p.jobs.leave(e)
+	p.wg.Done()
 }
 
+// zeroizeWaitgroup drops the jobs not yet added from the waitgroup, as they will never run.
+// Jobs already added must still call p.wg.Done() themselves. As it only drops what is left
+// of p.size it is safe for any number of cancelled jobs to call it at once.
 func (p *fixedPool) zeroizeWaitgroup() {
 	p.mux.Lock()
+	p.zeroize()
+	p.mux.Unlock()
+}
+
+// zeroize is zeroizeWaitgroup() with p.mux already held.
+func (p *fixedPool) zeroize() {
 	if p.size > 0 {
-		p.wg.Add(-(p.size + 1))
+		p.wg.Add(-p.size)
 		p.size = 0
 	}
-	p.mux.Unlock()
 }
 
 // AddNoWait adds a new job to be ran. When called it will not block until a free thread is created.
 //
 //	Instead it will spawn a goroutine that will wait until a free thread is available.
 func (p *fixedPool) AddNoWait(f func()) {
+	p.addNoWait(f, "", nil)
+}
+
+// AddJob adds a new job like AddNoWait() and returns a handle to it, to wait on that job
+// alone or check if it has started.
+func (p *fixedPool) AddJob(f func()) *Job {
+	j := newJob()
+	p.addNoWait(j.wrap(f), "", j)
+	return j
+}
+
+// addNoWait is AddNoWait() for a job with label. The channel of handle, which may be nil, is
+// closed once the job returns or is dropped.
+func (p *fixedPool) addNoWait(f func(), label string, handle *Job) {
+	if _, ok := p.depth(); !ok {
+		p.tooDeep()
+		handle.finish()
+		return
+	}
+
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
 	if p.size == 0 {
+		handle.finish()
 		return
 	}
 
 	p.size--
+	submitted := time.Now()
+	e := p.jobs.join()
+	job := p.job(f, label)
 
+	atomic.AddInt64(&p.spawned, 1)
 	go func() {
-		defer p.wg.Done()
-		select {
-		case <-p.c:
-		case <-p.ctx.Done():
+		defer handle.finish()
+		if !p.acquireJob() {
 			// we zeroize the waitgroup
 			p.zeroizeWaitgroup()
+			p.jobs.leave(e)
+			p.wg.Done()
 			return
 		}
-		f()
-		p.c <- true
+		p.latency.add(time.Since(submitted))
+		runJob(job, func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
 	}()
 }
 
-// ForceFinish provides an easy method prevent any future Add() from executing and prevent
-// any waiting goroutines from AddNoWait() from starting
-func (p *fixedPool) ForceFinish() {
-	p.ctxCancel()
-}
-
-// Wait when called will block until all threads are completed. Note the pool will not be
-// finished until all jobs have been queued and finished.
-func (p *fixedPool) Wait() {
-	p.wg.Wait()
-}
+// TryAdd adds a new job like Add() if a thread is free right now and returns true. Otherwise
+// it returns false straight away without adding the job, which then does not count towards
+// totalJobs. This suits shedding load rather than letting callers pile up.
+//
+//	It also returns false while paused, once the pool's context is done, once totalJobs
+//	have been added, or for a job nested deeper than WithMaxDepth() allows.
+func (p *fixedPool) TryAdd(f func()) bool {
+	if p.IsDone() || p.pause.wait() != nil {
+		return false
+	}
+	if _, ok := p.depth(); !ok {
+		return false
+	}
 
-// IsDone will return the status of the context if it is Done. If false it means
-// additional Add*s() are still needed.
-func (p *fixedPool) IsDone() bool {
+	p.mux.Lock()
+	if p.size == 0 {
+		p.mux.Unlock()
+		return false
+	}
 	select {
-	case <-p.ctx.Done():
-		return true
+	case <-p.threads():
 	default:
+		p.mux.Unlock()
 		return false
 	}
-}
+	p.size--
+	p.mux.Unlock()
 
-type dynamicPool struct {
-	mux       sync.Mutex
-	ctx       context.Context
-	ctxCancel context.CancelFunc
-	c         chan bool
-	wg        sync.WaitGroup
+	e := p.jobs.join()
+	atomic.AddInt64(&p.spawned, 1)
+	go runJob(p.job(f, ""), func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	return true
 }
 
-// New creates a thread pool with concurrentThreads limiter.
-//
-//		This Pool most aligns with a WaitGroup's Add() and Wait(),
-//	 with the additional layer of throttling running threads to a max
-//	 of concurrentThreads concurrently running.
+// AddOnce adds a new job to be ran only the first time key is seen. Like Add() it will block
+// until a free thread can work on the job.
 //
-// If concurrentThreads is <=0 it will assume runtime.NumCPU().
-func New(ctx context.Context, concurrentThreads int) Pool {
-	if concurrentThreads <= 0 {
-		concurrentThreads = runtime.NumCPU()
-	}
-
-	c := make(chan bool, concurrentThreads)
-	for i := 0; i < concurrentThreads; i++ {
-		c <- true
+//	Later calls with the same key do not run f but still count towards totalJobs,
+//	so Wait() will wait for the single execution.
+func (p *fixedPool) AddOnce(key string, f func()) {
+	if p.once.first(key) {
+		p.Add(f)
+		return
 	}
 
-	cCtx, can := context.WithCancel(ctx)
-	p := dynamicPool{
-		mux:       sync.Mutex{},
-		ctx:       cCtx,
-		ctxCancel: can,
-		c:         c,
-		wg:        sync.WaitGroup{},
-	}
+	p.skip()
+}
 
-	return &p
+// tooDeep drops a job nested deeper than WithMaxDepth() allows.
+func (p *fixedPool) tooDeep() {
+	p.drops.add(DropMaxDepth)
+	p.skip()
 }
 
-// Add adds a new job to be ran. When called it will blocks until a free thread can work on the job.
-func (p *dynamicPool) Add(f func()) {
-	p.wg.Add(1)
-	select {
-	case <-p.ctx.Done():
-		p.wg.Done()
+// skip counts a job that will not be ran towards totalJobs.
+func (p *fixedPool) skip() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.size == 0 {
 		return
-	case <-p.c:
 	}
-	go func() {
-		f()
-		p.c <- true
-		p.wg.Done()
-	}()
+
+	p.size--
+	p.wg.Done()
 }
 
-// AddNoWait adds a new job to be ran. When called it will not block until a free thread is created.
+// AddRetryIf adds a new job like Add() that calls f up to attempts times. f is only called
+// again if it returned an error that retryable returns true for, after waiting backoff. The
+// thread is held while waiting, and ForceFinish() stops any further attempts.
 //
-//	Instead it will spawn a goroutine that will wait until a free thread is available.
-func (p *dynamicPool) AddNoWait(f func()) {
-	p.wg.Add(1)
-	go func() {
-		defer p.wg.Done()
-		select {
-		case <-p.ctx.Done():
-			return
-		case <-p.c:
+//	The error from the last attempt, if any, is kept for Errors() like AddErr().
+func (p *fixedPool) AddRetryIf(attempts int, backoff time.Duration, retryable func(error) bool, f func() error) {
+	p.AddErr(retryIf(p.ctx(), attempts, backoff, retryable, f))
+}
+
+// AddRetry adds a new job like AddErr() that calls f up to attempts times, for as long as it
+// returns an error. After the failed call numbered attempt, counting from 1, it waits
+// backoff(attempt) before calling f again. The thread is held throughout, so the retries
+// count against the pool's threads, and ForceFinish() cuts the wait short and stops any
+// further attempts. The error from the last attempt is kept for Errors().
+func (p *fixedPool) AddRetry(f func() error, attempts int, backoff func(attempt int) time.Duration) {
+	p.AddErr(retry(p.ctx(), attempts, backoff, func(error) bool { return true }, f))
+}
+
+// AddErr adds a new job like Add() that returns an error. Any non-nil error is kept and
+// returned by Errors(). With WithCancelOnError() the first one finishes the pool, and with
+// WithErrorThreshold() the one reaching the threshold does.
+func (p *fixedPool) AddErr(f func() error) {
+	p.Add(func() {
+		err := f()
+		n := p.errs.add(err)
+		switch {
+		case err == nil:
+		case p.cfg.cancelOnErr:
+			p.forceFinish(err)
+		case n == p.cfg.errThreshold:
+			p.forceFinish(p.errs.first(n))
 		}
-		f()
-		p.c <- true
-	}()
+	})
+}
+
+// Errors returns every non-nil error returned by the jobs from AddErr() and AddRetryIf(), in
+// the order they were returned. It is meant to be called after Wait(), before then it only
+// has the errors of the jobs completed so far.
+func (p *fixedPool) Errors() []error {
+	return p.errs.list()
+}
+
+// AddIdempotent adds a new job like Add() that is ran a second time if it panics, in case
+// the panic was caused by a passing condition. Only a panic from the second run is given to
+// the panic handler.
+//
+//	f must be safe to run twice, including after being stopped part way through.
+func (p *fixedPool) AddIdempotent(f func()) {
+	p.Add(retryOnPanic(f))
 }
 
 // ForceFinish provides an easy method prevent any future Add() from executing and prevent
 // any waiting goroutines from AddNoWait() from starting
-func (p *dynamicPool) ForceFinish() {
-	p.ctxCancel()
+//
+//	The jobs not yet added are no longer waited on, so Wait() returns once the jobs
+//	already running complete. It is safe to call from within a job.
+func (p *fixedPool) ForceFinish() {
+	p.forceFinish(nil)
+}
+
+// ForceFinished is ForceFinish() returning true if this call finished the pool, and false if
+// the pool was already finished, such as by another call racing it, so only one caller acts
+// on the shutdown.
+func (p *fixedPool) ForceFinished() bool {
+	_, first := p.forceFinish(nil)
+	return first
+}
+
+// ForceFinishN is ForceFinish() returning the number of jobs that will now never run: the
+// jobs left of totalJobs plus the jobs waiting on a free thread. Calls after the first, or
+// once the pool's context is done, return 0.
+//
+//	A job getting a thread at the very moment of the call may be counted even though it
+//	runs.
+func (p *fixedPool) ForceFinishN() int {
+	n, _ := p.forceFinish(nil)
+	return n
+}
+
+// forceFinish is ForceFinishN() with cause given as the cause of the context being done. It
+// also returns true if this call finished the pool.
+func (p *fixedPool) forceFinish(cause error) (int, bool) {
+	p.mux.Lock()
+	if p.ctx().Err() != nil {
+		p.mux.Unlock()
+		return 0, false
+	}
+	n := p.size + int(atomic.LoadInt64(&p.waiting))
+	p.cancel(cause)
+	p.mux.Unlock()
+
+	p.zeroizeWaitgroup()
+	return n, true
+}
+
+// Drain stops any more jobs from being added while letting the jobs already added, running
+// or waiting on a thread, complete. Wait() then returns once they have, without needing the
+// rest of totalJobs. Unlike ForceFinish() the context is left alone, so IsDone() stays false
+// while Draining() is true.
+func (p *fixedPool) Drain() {
+	atomic.StoreInt32(&p.draining, 1)
+	p.zeroizeWaitgroup()
+}
+
+// CommitRemaining is Drain() returning the number of totalJobs that were never added and
+// are now given up on, so Wait() returns once the jobs already added complete rather than
+// waiting forever on the rest. Only the first call gives up any jobs, later calls return 0.
+func (p *fixedPool) CommitRemaining() int {
+	atomic.StoreInt32(&p.draining, 1)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	n := p.size
+	p.zeroize()
+	return n
+}
+
+// Close lets the jobs already added complete, like Drain() followed by Wait(), and then
+// finishes the pool so none of its goroutines are left behind. Err() then returns
+// ErrPoolClosed, which AddOrErr() rejects any more jobs with. It is safe to call more than
+// once, but not from within one of the pool's jobs as it would wait on itself.
+func (p *fixedPool) Close() error {
+	p.Drain()
+	p.Wait()
+	p.forceFinish(ErrPoolClosed)
+	return nil
+}
+
+// Draining returns true once Drain() has been called.
+func (p *fixedPool) Draining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
 }
 
 // Wait when called will block until all threads are completed. Note the pool will not be
 // finished until all jobs have been queued and finished.
-func (p *dynamicPool) Wait() {
+//
+//	Any number of goroutines may wait at once, even while jobs are being added or the
+//	pool is being finished.
+func (p *fixedPool) Wait() {
 	p.wg.Wait()
 }
 
-// IsDone will return the status of the context if it is Done. A value true indicates the pool
-func (p *dynamicPool) IsDone() bool {
+// WaitErr is Wait() returning the cause of the pool's context being done, as given by Err(),
+// or nil if it is not done. Cancelling the parent context, such as the one from an errgroup,
+// stops the pool like ForceFinish() does and WaitErr() returns the parent's cause.
+func (p *fixedPool) WaitErr() error {
+	p.Wait()
+	return p.Err()
+}
+
+// WaitN blocks until at least n jobs have completed, counting every job completed since the
+// pool was created as Stats() does, and then returns without waiting for the rest. It also
+// returns once the pool is finished, so ForceFinish() releases it if fewer than n jobs will
+// ever complete.
+func (p *fixedPool) WaitN(n int) {
+	p.completed.wait(p.ctx(), int64(n))
+}
+
+// WaitUntil blocks until pred returns true for the pool's Stats(), which are checked again
+// each time a job starts or completes, and returns true. It returns false once the pool is
+// finished, such as by ForceFinish(), so a pred that never becomes true does not block
+// forever. pred is not called concurrently and must not block.
+func (p *fixedPool) WaitUntil(pred func(Stats) bool) bool {
+	return p.completed.until(p.ctx(), func() bool { return pred(p.Stats()) })
+}
+
+// WaitCtx is Wait() giving up once ctx is done, returning ctx's error then and nil if all
+// jobs completed first. Giving up does not stop any jobs, the pool carries on regardless.
+// A nil ctx is treated as context.Background().
+func (p *fixedPool) WaitCtx(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-p.wg.done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitTimeout is Wait() giving up after d. It returns true if all jobs completed in time and
+// false otherwise, without stopping any jobs.
+func (p *fixedPool) WaitTimeout(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
 	select {
-	case <-p.ctx.Done():
+	case <-p.wg.done():
 		return true
-	default:
+	case <-t.C:
 		return false
 	}
 }
+
+// WaitHeartbeat is Wait() calling beat with the pool's Stats() every interval until all jobs
+// have completed, to show the pool is alive during a long batch. beat is not called once Wait()
+// would return, and is called from the waiting goroutine so it never runs concurrently.
+func (p *fixedPool) WaitHeartbeat(every time.Duration, beat func(Stats)) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	done := p.wg.done()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		// the pool may have drained while the tick was waiting
+		select {
+		case <-done:
+			return
+		default:
+		}
+		beat(p.Stats())
+	}
+}
+
+// Pause stops any more jobs from starting until Resume() is called. Jobs already running
+// are not affected.
+//
+//	While paused Add() blocks, unless there is room in the buffer given by
+//	WithPauseBuffer(), and the goroutines from AddNoWait() wait.
+func (p *fixedPool) Pause() {
+	p.pause.pause()
+}
+
+// Resume lets jobs start again after Pause(), including any buffered while paused.
+func (p *fixedPool) Resume() {
+	p.pause.resume()
+}
+
+// Then adds f as a job that starts once every job added before the call to Then() has
+// completed, without blocking. f may add more jobs, so work can be done in phases on one
+// pool. Jobs added after the call are not waited on and may run before f. f counts towards
+// totalJobs like any other job.
+func (p *fixedPool) Then(f func()) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.size == 0 {
+		return
+	}
+
+	p.size--
+	wait := p.jobs.barrier()
+	e := p.jobs.join()
+	job := p.job(f, "")
+
+	atomic.AddInt64(&p.spawned, 1)
+	go func() {
+		wait()
+		if !p.acquireJob() {
+			// we zeroize the waitgroup
+			p.zeroizeWaitgroup()
+			p.jobs.leave(e)
+			p.wg.Done()
+			return
+		}
+		runJob(job, func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	}()
+}
+
+// Handoff hands the thread of the calling job to f, which is ran as soon as the calling job
+// returns without the thread going back to the pool, so no other job can take it first. It
+// returns false without running f if the pool was not created with WithNestedJobs(), if
+// not called from one of the pool's jobs, or once totalJobs have been added. f counts towards totalJobs like any other job.
+//
+//	Jobs handed off by one job run one after another in the order they were handed off.
+//	If the pool's context is done by the time the calling job returns f is not ran.
+func (p *fixedPool) Handoff(f func()) bool {
+	if !p.workers.current() {
+		return false
+	}
+
+	p.mux.Lock()
+	if p.size == 0 {
+		p.mux.Unlock()
+		return false
+	}
+	p.size--
+	p.mux.Unlock()
+
+	e := p.jobs.join()
+	job := p.job(f, "")
+	p.workers.handoff(func() {
+		if p.ctx().Err() != nil {
+			// we zeroize the waitgroup
+			p.zeroizeWaitgroup()
+		} else {
+			job()
+		}
+		p.jobs.leave(e)
+		p.wg.Done()
+	})
+	return true
+}
+
+// Barrier returns a function that when called will block until all jobs added before
+// the call to Barrier() are completed. Jobs added after the call are not waited on.
+func (p *fixedPool) Barrier() func() {
+	return p.jobs.barrier()
+}
+
+// DrainProgress returns a channel that receives the number of jobs still to complete each
+// time a job completes, and is closed once all jobs have completed.
+//
+//	Only the jobs added before the call are given room in the channel, so jobs added
+//	afterwards may not be reported. If no jobs are running the channel is already closed.
+func (p *fixedPool) DrainProgress() <-chan int {
+	return p.jobs.drain()
+}
+
+// Acquire blocks until a thread is free and takes it for the caller, using the pool as a
+// semaphore for code that does not fit in a job. Every successful Acquire() must be
+// followed by a Release(). ctx's error is returned if ctx is done first, and ErrPoolClosed if
+// the pool is finished first, without taking a thread. A nil ctx is treated as
+// context.Background().
+func (p *fixedPool) Acquire(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if p.ctx().Err() != nil {
+		return ErrPoolClosed
+	}
+
+	if err := takeThread(&p.state, ctx); err != nil {
+		return err
+	}
+	if p.ctx().Err() != nil {
+		p.giveBack()
+		return ErrPoolClosed
+	}
+	atomic.AddInt64(&p.acquired, 1)
+	return nil
+}
+
+// Release frees a thread taken by Acquire(). It panics if there is no such thread to free,
+// as that would let more than the pool's threads run at once.
+func (p *fixedPool) Release() {
+	for {
+		n := atomic.LoadInt64(&p.acquired)
+		if n == 0 {
+			panic("threadpool: Release called without a matching Acquire")
+		}
+		if atomic.CompareAndSwapInt64(&p.acquired, n, n-1) {
+			break
+		}
+	}
+	p.giveBack()
+}
+
+// ReserveSlots blocks until n threads are free and reserves them for the returned Reservation.
+// Jobs added through the Reservation start immediately and count towards totalJobs. Unused
+// threads must be given back with Release().
+//
+//	Only one reservation is made at a time, so two callers can never each hold part of
+//	the threads the other is waiting on.
+//
+// If n is more than Concurrency() ErrReserveCapacity is returned. If the context is done
+// before all n threads are free its error is returned.
+func (p *fixedPool) ReserveSlots(n int) (*Reservation, error) {
+	if n < 0 || n > p.Concurrency() {
+		return nil, ErrReserveCapacity
+	}
+
+	p.reserveMux.Lock()
+	defer p.reserveMux.Unlock()
+
+	for i := 0; i < n; i++ {
+		if !p.acquire() {
+			for ; i > 0; i-- {
+				p.releaseThread()
+			}
+			return nil, p.ctx().Err()
+		}
+		atomic.AddInt64(&p.reserved, 1)
+	}
+	return &Reservation{pool: p, slots: n}, nil
+}
+
+// AddGroup adds the jobs in fs so that they all start at the same moment. It blocks until a
+// thread is free for every job, using ReserveSlots(), and then lets them all run at once.
+// This is useful for measuring how jobs behave when contending with each other. Jobs past
+// totalJobs are not ran.
+//
+// The errors are the same as ReserveSlots() with n of len(fs).
+func (p *fixedPool) AddGroup(fs []func()) error {
+	return addGroup(p, fs)
+}
+
+// AddWeighted adds a new job that takes weight threads rather than one, for jobs heavy enough
+// to count as several, such as ones using a lot of memory. Like ReserveSlots() it blocks
+// until weight threads are free, and they are all freed once f returns.
+//
+// ErrWeight is returned for a weight of less than 1, and ErrReserveCapacity for one more than
+// Concurrency(), as it could never run. If the context is done first its error is
+// returned, and ErrPoolClosed if the pool will not run any more jobs.
+func (p *fixedPool) AddWeighted(f func(), weight int) error {
+	return addWeighted(p, f, weight)
+}
+
+func (p *fixedPool) startReserved(f func()) bool {
+	p.mux.Lock()
+	if p.size == 0 {
+		p.mux.Unlock()
+		return false
+	}
+
+	p.size--
+	p.mux.Unlock()
+	if p.ctx().Err() != nil {
+		p.zeroizeWaitgroup()
+		p.wg.Done()
+		return false
+	}
+	e := p.jobs.join()
+	atomic.AddInt64(&p.reserved, -1)
+	atomic.AddInt64(&p.spawned, 1)
+	go runJob(p.job(f, ""), func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	return true
+}
+
+func (p *fixedPool) releaseThread() {
+	atomic.AddInt64(&p.reserved, -1)
+	p.giveBack()
+}
+
+// held reports whether any thread is taken by Acquire() or a Reservation. Such a thread would
+// be given back to the new threads of Reset() or Reopen() and overfill them.
+func (p *fixedPool) held() bool {
+	return atomic.LoadInt64(&p.acquired) > 0 || atomic.LoadInt64(&p.reserved) > 0
+}
+
+// SetPanicHandler replaces the panic handler given by WithPanicHandlerCtx(). It is safe to call
+// while jobs are running, any panic recovered after it returns is given to handler. A nil
+// handler goes back to logging panics.
+func (p *fixedPool) SetPanicHandler(handler func(ctx context.Context, recovered any, stack []byte)) {
+	p.panics.store(handler)
+}
+
+// PanickedJobs returns the jobs whose panic was recovered since the last call, so they can
+// be looked at or added again.
+//
+//	The pool keeps a reference to each of these jobs, and whatever they hold on to,
+//	until PanickedJobs() is called. Call it regularly if many jobs may panic.
+func (p *fixedPool) PanickedJobs() []func() {
+	return p.panics.take()
+}
+
+// QueueLatencyPercentiles returns estimated percentiles of how long jobs waited for a free
+// thread after being added. The estimate is made from a random sample of the jobs.
+func (p *fixedPool) QueueLatencyPercentiles() LatencyPercentiles {
+	return p.latency.percentiles()
+}
+
+// AddLabeled adds a new job like Add() and records how long it ran under label, to be
+// reported by LatencyByLabel().
+func (p *fixedPool) AddLabeled(label string, f func()) {
+	p.add(func() {
+		start := time.Now()
+		defer func() { p.latency.addLabeled(label, time.Since(start)) }()
+		f()
+	}, label)
+}
+
+// AddIfFresh adds a new job like Add() that only runs f if stillNeeded returns true once
+// the job has a thread, so work that went stale while waiting does not use up the thread.
+// A skipped job is counted by Dropped() as DropStale.
+func (p *fixedPool) AddIfFresh(stillNeeded func() bool, f func()) {
+	p.Add(func() {
+		if !stillNeeded() {
+			p.drops.add(DropStale)
+			return
+		}
+		f()
+	})
+}
+
+// AddCtx adds a new job like Add() for f, which is given a context that is done once either
+// the pool's context or ctx is done, so a single job can be cancelled. If ctx is already done
+// by the time the job has a thread f is not called, and Dropped() counts it as DropExpired.
+// A nil ctx is treated as context.Background().
+func (p *fixedPool) AddCtx(ctx context.Context, f func(context.Context)) {
+	p.Add(ctxJob(p.ctx(), ctx, f, &p.drops))
+}
+
+// AddWithValues adds a new job like Add() for f, which is given a context carrying the values
+// of ctx, such as trace IDs, but done only once the pool's context is. Unlike AddCtx()
+// cancelling ctx does not stop the job. A nil ctx is treated as context.Background().
+func (p *fixedPool) AddWithValues(ctx context.Context, f func(context.Context)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.Add(ctxJob(p.ctx(), context.WithoutCancel(ctx), f, &p.drops))
+}
+
+// Dropped returns the number of jobs added but not ran for each reason, DropStale,
+// DropExpired or DropMaxDepth.
+func (p *fixedPool) Dropped() map[string]int64 {
+	return p.drops.counts()
+}
+
+// LatencyByLabel returns the stats of how long the completed jobs from AddLabeled() ran,
+// for each label. The mean is over every job while P95 is estimated from a random sample.
+func (p *fixedPool) LatencyByLabel() map[string]LatencyStats {
+	return p.latency.byLabel()
+}
+
+// LeakedGoroutines returns the number of jobs still running after their thread was
+// reclaimed by WithSlotReclaim().
+func (p *fixedPool) LeakedGoroutines() int64 {
+	return atomic.LoadInt64(&p.leaked)
+}
+
+// GoroutinesSpawned returns the number of goroutines the pool has started to run or wait
+// on jobs. Every job is given its own goroutine, apart from jobs ran inline by Add() or
+// handed off with Handoff().
+func (p *fixedPool) GoroutinesSpawned() int64 {
+	return atomic.LoadInt64(&p.spawned)
+}
+
+// Err returns nil until the pool's context is done and then returns the cause:
+// context.Canceled after ForceFinish(), ErrMaxLifetime after the duration given to
+// WithMaxLifetime(), or the cause of the parent context being done.
+func (p *fixedPool) Err() error {
+	return context.Cause(p.ctx())
+}
+
+// finished returns a channel closed once the pool will not run any more jobs.
+func (p *fixedPool) finished() <-chan struct{} {
+	return p.ctx().Done()
+}
+
+// Concurrency returns the number of jobs ran at once. It is the concurrentThreads the pool
+// was created with, after defaults were applied, unless changed by Resize().
+func (p *fixedPool) Concurrency() int {
+	return int(atomic.LoadInt64(&p.limit))
+}
+
+// Total returns the totalJobs the pool was created with.
+func (p *fixedPool) Total() int {
+	return p.cfg.total
+}
+
+// String describes the pool and what it is doing for logs and debugging, using its name from
+// WithName(). It is a snapshot like Stats() and as cheap.
+func (p *fixedPool) String() string {
+	return describe(p.cfg.name, p.Kind(), p.Concurrency(), p.Stats())
+}
+
+// Config returns the settings the pool was created with, after defaults were applied.
+func (p *fixedPool) Config() Config {
+	return p.cfg.export()
+}
+
+// Clone creates a new pool with the same settings as p, given by Config(), but none of its
+// jobs or state. The new pool uses ctx in place of p's context. A nil ctx is treated as
+// context.Background().
+//
+//	The panic handler is the one given by WithPanicHandlerCtx(), not any set later with
+//	SetPanicHandler(). A source given by WithRandSource() is not shared, the new pool is
+//	given a new source seeded from it.
+func (p *fixedPool) Clone(ctx context.Context) Pool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return newFixedPool(ctx, p.latency.clone(p.cfg))
+}
+
+// Reopen returns ErrReopenFixed, as the jobs dropped by ForceFinish() cannot be added back
+// to totalJobs. Reset() starts the pool over for a whole new totalJobs instead.
+func (p *fixedPool) Reopen() error {
+	return ErrReopenFixed
+}
+
+// Reset re-arms the pool for another totalJobs, with a new context from the one it was
+// created with and a new set of threads, as if it was just created. It works whether or not
+// the pool was finished, so the same pool can be used for batch after batch. The options are
+// applied afresh, but the counts such as Stats() carry on.
+//
+//	ErrJobsInFlight is returned while any job is still added or running, or a thread
+//	taken by Acquire() or ReserveSlots() is not released, and the parent context's error
+//	if it is done. Reset must not be called while jobs are being added.
+func (p *fixedPool) Reset() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.jobs.active() || p.held() {
+		return ErrJobsInFlight
+	}
+	if p.parent.Err() != nil {
+		return context.Cause(p.parent)
+	}
+	p.cancel(nil)
+	p.wg.Add(p.cfg.total - p.size)
+	p.size = p.cfg.total
+	p.open()
+	return nil
+}
+
+// Resize changes the number of jobs ran at once to n, while jobs are running. Growing frees
+// the extra threads at once. Shrinking takes free threads at once and the rest as running
+// jobs complete, so more than n jobs may run until then.
+//
+//	n may be more than the concurrentThreads the pool was created with, so the pool can
+//	grow as well as shrink. n must be at least 1, otherwise ErrResize is returned and
+//	nothing is changed. Config() keeps reporting the concurrentThreads the pool was
+//	created with, Concurrency() reports n.
+func (p *fixedPool) Resize(n int) error {
+	if n <= 0 {
+		return ErrResize
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if n > cap(p.threads()) {
+		p.grow(n)
+	}
+
+	diff := n - int(atomic.LoadInt64(&p.limit))
+	atomic.StoreInt64(&p.limit, int64(n))
+	for ; diff < 0; diff++ {
+		select {
+		case <-p.threads():
+		default:
+			atomic.AddInt64(&p.owed, 1)
+		}
+	}
+	for ; diff > 0; diff-- {
+		p.giveBack()
+	}
+	return nil
+}
+
+// giveBack returns a thread to the pool, unless it is owed to a Resize() shrinking the pool.
+func (p *fixedPool) giveBack() {
+	p.giveBackFrom(nil)
+}
+
+// giveBackFrom is giveBack() for a thread taken while the pool's context was ctx. It is
+// dropped if Reset() or Reopen() have given the pool new threads since. A nil ctx matches any.
+func (p *fixedPool) giveBackFrom(ctx context.Context) {
+	p.stateMux.RLock()
+	defer p.stateMux.RUnlock()
+
+	st := p.state.Load()
+	if ctx != nil && st.ctx != ctx {
+		return
+	}
+	if p.cfg.stallDetect > 0 {
+		p.stuck.threadFreed()
+	}
+	for {
+		owed := atomic.LoadInt64(&p.owed)
+		if owed == 0 {
+			st.c <- true
+			return
+		}
+		if atomic.CompareAndSwapInt64(&p.owed, owed, owed-1) {
+			return
+		}
+	}
+}
+
+// grow replaces the pool's threads with a channel with room for n, moving the free threads
+// over. Jobs waiting on the old channel are woken to wait on the new one.
+func (p *fixedPool) grow(n int) {
+	p.stateMux.Lock()
+	defer p.stateMux.Unlock()
+
+	old := p.state.Load()
+	c := make(chan bool, n)
+	for moved := false; !moved; {
+		select {
+		case t := <-old.c:
+			c <- t
+		default:
+			moved = true
+		}
+	}
+	p.state.Store(&poolState{ctx: old.ctx, cancel: old.cancel, c: c, replaced: make(chan struct{}), lifetime: old.lifetime})
+	close(old.replaced)
+}
+
+// Stats returns a snapshot of what the pool is doing. It is cheap enough to poll often.
+func (p *fixedPool) Stats() Stats {
+	available := len(p.threads())
+	p.mux.Lock()
+	pending := p.size
+	p.mux.Unlock()
+
+	return Stats{
+		Running:       int(atomic.LoadInt64(&p.limit)+atomic.LoadInt64(&p.owed)) - available,
+		Available:     available,
+		Completed:     p.completed.count(),
+		Panicked:      atomic.LoadInt64(&p.panics.count),
+		Pending:       pending,
+		Waiting:       int(atomic.LoadInt64(&p.waiting)),
+		WaitCount:     atomic.LoadInt64(&p.waitCount),
+		TotalWaitTime: time.Duration(atomic.LoadInt64(&p.waitNanos)),
+	}
+}
+
+// Kind returns Fixed.
+func (p *fixedPool) Kind() PoolKind {
+	return Fixed
+}
+
+// IsDone will return the status of the context if it is Done. If false it means
+// additional Add*s() are still needed.
+func (p *fixedPool) IsDone() bool {
+	select {
+	case <-p.ctx().Done():
+		return true
+	default:
+		return false
+	}
+}
+
+type dynamicPool struct {
+	cfg        config
+	mux        sync.Mutex
+	reserveMux sync.Mutex
+	parent     context.Context
+	state      atomic.Pointer[poolState]
+	stateMux   sync.RWMutex // held for writing while the threads are replaced
+	wg         counter
+	once       onceKeys
+	jobs       tracker
+	leaked     int64
+	spawned    int64
+	completed  completions
+	waiting    int64
+	waitCount  int64
+	waitNanos  int64
+	limit      int64
+	owed       int64
+	acquired   int64
+	reserved   int64 // threads held by a Reservation and not yet used
+	draining   int32
+	panics     panicHandler
+	latency    reservoir
+	stalls     stallWatch
+	drops      drops
+	limiter    *rate.Limiter
+	stuck      stallDetector
+	errs       jobErrors
+	pause      pauser
+	workers    workers
+}
+
+// New creates a thread pool with concurrentThreads limiter.
+//
+//		This Pool most aligns with a WaitGroup's Add() and Wait(),
+//	 with the additional layer of throttling running threads to a max
+//	 of concurrentThreads concurrently running.
+//
+// If concurrentThreads is <=0 it will assume runtime.NumCPU(). A nil ctx is treated as
+// context.Background(). concurrentThreads takes the place of any WithConcurrency() in opts.
+func New(ctx context.Context, concurrentThreads int, opts ...Option) Pool {
+	return NewWithOptions(ctx, append(opts[:len(opts):len(opts)], WithConcurrency(concurrentThreads))...)
+}
+
+// NewWithOptions is New() taking concurrentThreads from WithConcurrency(), so the whole
+// configuration is given as options. WithTotal() is ignored, see NewFixedSizeWithOptions().
+func NewWithOptions(ctx context.Context, opts ...Option) Pool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cfg := newConfig(opts)
+	cfg.kind = Dynamic
+	if cfg.concurrency <= 0 {
+		cfg.concurrency = runtime.NumCPU()
+	}
+	cfg.total = -1
+	return newDynamicPool(ctx, cfg)
+}
+
+func newDynamicPool(ctx context.Context, cfg config) *dynamicPool {
+	p := dynamicPool{
+		cfg:    cfg,
+		mux:    sync.Mutex{},
+		parent: ctx,
+	}
+	p.open()
+	p.panics.store(p.cfg.panicHandler)
+	p.pause.size = p.cfg.pauseBuffer
+	p.latency.rand = p.cfg.rand
+	p.stalls.after = p.cfg.stallAfter
+	p.stalls.onStall = p.cfg.onStall
+	p.workers.tracked = p.cfg.nestedJobs || p.cfg.maxDepth > 0
+	p.limiter = p.cfg.newLimiter()
+
+	return &p
+}
+
+// open gives the pool a new context from its parent and a new set of threads.
+func (p *dynamicPool) open() {
+	cCtx, can := context.WithCancelCause(p.parent)
+	c := make(chan bool, p.cfg.concurrency)
+	var lifetime *time.Timer
+	if p.cfg.maxLifetime > 0 {
+		lifetime = time.AfterFunc(p.cfg.maxLifetime, func() { can(ErrMaxLifetime) })
+	}
+	// the state is in place before any thread is given back to it
+	p.stateMux.Lock()
+	if old := p.state.Load(); old != nil && old.lifetime != nil {
+		old.lifetime.Stop()
+	}
+	p.state.Store(&poolState{ctx: cCtx, cancel: can, c: c, replaced: make(chan struct{}), lifetime: lifetime})
+	p.stateMux.Unlock()
+	atomic.StoreInt64(&p.limit, int64(p.cfg.concurrency))
+	atomic.StoreInt64(&p.owed, 0)
+	atomic.StoreInt32(&p.draining, 0)
+
+	giveBack := func() { p.giveBackFrom(cCtx) }
+	fillThreads(cCtx, c, p.cfg.rampUp, giveBack)
+	if p.cfg.gcBackoff {
+		go backoffOnGC(cCtx, p.threads, giveBack, gcCycles, gcBackoffInterval)
+	}
+	if p.cfg.stallDetect > 0 {
+		go p.stuck.run(cCtx, p.cfg.stallDetect, p.jobs.active)
+	}
+}
+
+// ctx returns the pool's context, which Reset() and Reopen() replace.
+func (p *dynamicPool) ctx() context.Context {
+	return p.state.Load().ctx
+}
+
+// cancel finishes the pool's context with cause, stopping the timer of WithMaxLifetime().
+func (p *dynamicPool) cancel(cause error) {
+	st := p.state.Load()
+	if st.lifetime != nil {
+		st.lifetime.Stop()
+	}
+	st.cancel(cause)
+}
+
+// threads returns the channel holding the pool's free threads.
+func (p *dynamicPool) threads() chan bool {
+	return p.state.Load().c
+}
+
+// Add adds a new job to be ran. When called it will blocks until a free thread can work on the job.
+//
+//	Called from one of the pool's own jobs it waits like any other caller, so jobs adding
+//	to their own pool can deadlock with every thread held by a job waiting on a free
+//	thread. With WithNestedJobs() such a job is ran inline instead of waiting.
+func (p *dynamicPool) Add(f func()) {
+	p.add(f, "")
+}
+
+// AddIndexed adds a new job like Add() that calls f with i, such as the index of the item it
+// is to work on. i is passed by value when AddIndexed() is called, so giving it a loop
+// variable is safe even where each iteration does not have its own copy, as before Go 1.22.
+func (p *dynamicPool) AddIndexed(i int, f func(int)) {
+	p.Add(func() { f(i) })
+}
+
+// AddBatch adds every job in fs like Add(), in order, blocking until each has a free thread.
+// ErrPoolClosed is returned without adding any job if the pool's context is already done.
+func (p *dynamicPool) AddBatch(fs []func()) error {
+	if p.ctx().Err() != nil {
+		return ErrPoolClosed
+	}
+	for _, f := range fs {
+		p.Add(f)
+	}
+	return nil
+}
+
+// AddAfter adds a new job like AddNoWait() that only starts waiting on a free thread once d
+// has passed, such as to stagger retries. It does not block. Wait() waits for the job
+// straight away, and if the pool is finished before d has passed it is dropped.
+func (p *dynamicPool) AddAfter(d time.Duration, f func()) {
+	if p.Draining() || p.ctx().Err() != nil {
+		return
+	}
+
+	p.wg.Add(1)
+	go p.after(p.ctx(), d, f, p.jobs.join())
+}
+
+// after adds f, already counted in p.wg and joined to epoch e, once d has passed.
+func (p *dynamicPool) after(ctx context.Context, d time.Duration, f func(), e *epoch) {
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+		p.addJoined(f, "", e)
+	case <-ctx.Done():
+		t.Stop()
+		p.jobs.leave(e)
+		p.wg.Done()
+	}
+}
+
+// AddOrErr adds a new job like Add(), blocking until a free thread can work on the job. If
+// the pool's context is done, before or while waiting, ErrPoolClosed is returned and the
+// job is not ran. ErrMaxDepth is returned for a job nested deeper than WithMaxDepth() allows.
+func (p *dynamicPool) AddOrErr(f func()) error {
+	if p.ctx().Err() != nil {
+		return ErrPoolClosed
+	}
+	if _, ok := p.depth(); !ok {
+		p.tooDeep()
+		return ErrMaxDepth
+	}
+	if !p.add(f, "") {
+		return ErrPoolClosed
+	}
+	return nil
+}
+
+// add is Add() for a job with label, returning false if the job will not be ran.
+func (p *dynamicPool) add(f func(), label string) bool {
+	if p.Draining() {
+		return false
+	}
+	if _, ok := p.depth(); !ok {
+		p.tooDeep()
+		return false
+	}
+	if p.pause.buffer() {
+		p.addNoWait(f, label, nil)
+		return true
+	}
+
+	p.wg.Add(1)
+	return p.addJoined(f, label, p.jobs.join())
+}
+
+// addJoined is add() for a job already counted in p.wg and joined to epoch e.
+func (p *dynamicPool) addJoined(f func(), label string, e *epoch) bool {
+	submitted := time.Now()
+	if p.nested() {
+		p.job(f, label)()
+		p.jobs.leave(e)
+		p.wg.Done()
+		return true
+	}
+	if !p.acquireJob() {
+		p.jobs.leave(e)
+		p.wg.Done()
+		return false
+	}
+	p.latency.add(time.Since(submitted))
+	atomic.AddInt64(&p.spawned, 1)
+	go runJob(p.job(f, label), func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	return true
+}
+
+// acquireJob is acquire() for a job, counting it as waiting until it returns and adding the
+// time it took to the wait stats.
+func (p *dynamicPool) acquireJob() bool {
+	atomic.AddInt64(&p.waiting, 1)
+	start := time.Now()
+	ok := p.acquire()
+	atomic.AddInt64(&p.waitCount, 1)
+	atomic.AddInt64(&p.waitNanos, int64(time.Since(start)))
+	atomic.AddInt64(&p.waiting, -1)
+	p.completed.changed()
+	return ok
+}
+
+// acquire blocks until a thread is free and returns true, or returns false if the
+// context is done first.
+func (p *dynamicPool) acquire() bool {
+	if resumed := p.pause.wait(); resumed != nil {
+		select {
+		case <-resumed:
+		case <-p.ctx().Done():
+			return false
+		}
+	}
+
+	if takeThread(&p.state, context.Background()) != nil {
+		return false
+	}
+	// when both were ready the context takes priority
+	if p.ctx().Err() != nil {
+		p.giveBack()
+		return false
+	}
+	return true
+}
+
+// job returns f with label wrapped to be ran by the pool. It must be called from the
+// goroutine adding the job so the job's depth is known.
+func (p *dynamicPool) job(f func(), label string) func() {
+	depth, _ := p.depth()
+	guarded := p.panics.guard(p.ctx(), p.stalls.watch(f, label))
+	return p.workers.run(rateLimited(p.ctx(), p.limiter, &p.drops, func() {
+		guarded()
+		n := p.completed.add()
+		if p.cfg.progress != nil {
+			p.cfg.progress(int(n), p.Total())
+		}
+	}), depth)
+}
+
+// depth returns the depth of a job added from the calling goroutine, 1 unless added from
+// within a job, and false if that is deeper than WithMaxDepth() allows. Without
+// WithMaxDepth() the depth is not tracked and is always 1.
+func (p *dynamicPool) depth() (int, bool) {
+	if p.cfg.maxDepth <= 0 {
+		return 1, true
+	}
+	d := p.workers.depth() + 1
+	return d, d <= p.cfg.maxDepth
+}
+
+// nested returns true if called from one of the pool's jobs while no thread is free.
+func (p *dynamicPool) nested() bool {
+	return len(p.threads()) == 0 && p.ctx().Err() == nil && p.workers.current()
+}
+
+// done returns the thread used by a job of epoch e and marks the job as completed.
+func (p *dynamicPool) done(e *epoch) {
+	p.giveBack()
+	p.completed.changed()
+	p.jobs.leave(e)
+	p.wg.Done()
+}
+
+// AddNoWait adds a new job to be ran. When called it will not block until a free thread is created.
+//
+//	Instead it will spawn a goroutine that will wait until a free thread is available.
+func (p *dynamicPool) AddNoWait(f func()) {
+	p.addNoWait(f, "", nil)
+}
+
+// AddJob adds a new job like AddNoWait() and returns a handle to it, to wait on that job
+// alone or check if it has started.
+func (p *dynamicPool) AddJob(f func()) *Job {
+	j := newJob()
+	p.addNoWait(j.wrap(f), "", j)
+	return j
+}
+
+// addNoWait is AddNoWait() for a job with label. The channel of handle, which may be nil, is
+// closed once the job returns or is dropped.
+func (p *dynamicPool) addNoWait(f func(), label string, handle *Job) {
+	if p.Draining() {
+		handle.finish()
+		return
+	}
+	if _, ok := p.depth(); !ok {
+		p.tooDeep()
+		handle.finish()
+		return
+	}
+
+	p.wg.Add(1)
+	submitted := time.Now()
+	e := p.jobs.join()
+	job := p.job(f, label)
+	atomic.AddInt64(&p.spawned, 1)
+	go func() {
+		defer handle.finish()
+		if !p.acquireJob() {
+			p.jobs.leave(e)
+			p.wg.Done()
+			return
+		}
+		p.latency.add(time.Since(submitted))
+		runJob(job, func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	}()
+}
+
+// TryAdd adds a new job like Add() if a thread is free right now and returns true. Otherwise
+// it returns false straight away without adding the job. This suits shedding load rather
+// than letting callers pile up.
+//
+//	It also returns false while paused, once the pool's context is done, or for a job
+//	nested deeper than WithMaxDepth() allows.
+func (p *dynamicPool) TryAdd(f func()) bool {
+	if p.IsDone() || p.Draining() || p.pause.wait() != nil {
+		return false
+	}
+	if _, ok := p.depth(); !ok {
+		return false
+	}
+
+	select {
+	case <-p.threads():
+	default:
+		return false
+	}
+
+	p.wg.Add(1)
+	e := p.jobs.join()
+	atomic.AddInt64(&p.spawned, 1)
+	go runJob(p.job(f, ""), func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	return true
+}
+
+// AddOnce adds a new job to be ran only the first time key is seen. Like Add() it will block
+// until a free thread can work on the job. Later calls with the same key are no-ops.
+func (p *dynamicPool) AddOnce(key string, f func()) {
+	if p.once.first(key) {
+		p.Add(f)
+	}
+}
+
+// tooDeep drops a job nested deeper than WithMaxDepth() allows.
+func (p *dynamicPool) tooDeep() {
+	p.drops.add(DropMaxDepth)
+}
+
+// skip does nothing, the dynamic pool only counts jobs that are ran.
+func (p *dynamicPool) skip() {}
+
+// AddRetryIf adds a new job like Add() that calls f up to attempts times. f is only called
+// again if it returned an error that retryable returns true for, after waiting backoff. The
+// thread is held while waiting, and ForceFinish() stops any further attempts.
+//
+//	The error from the last attempt, if any, is kept for Errors() like AddErr().
+func (p *dynamicPool) AddRetryIf(attempts int, backoff time.Duration, retryable func(error) bool, f func() error) {
+	p.AddErr(retryIf(p.ctx(), attempts, backoff, retryable, f))
+}
+
+// AddRetry adds a new job like AddErr() that calls f up to attempts times, for as long as it
+// returns an error. After the failed call numbered attempt, counting from 1, it waits
+// backoff(attempt) before calling f again. The thread is held throughout, so the retries
+// count against the pool's threads, and ForceFinish() cuts the wait short and stops any
+// further attempts. The error from the last attempt is kept for Errors().
+func (p *dynamicPool) AddRetry(f func() error, attempts int, backoff func(attempt int) time.Duration) {
+	p.AddErr(retry(p.ctx(), attempts, backoff, func(error) bool { return true }, f))
+}
+
+// AddErr adds a new job like Add() that returns an error. Any non-nil error is kept and
+// returned by Errors(). With WithCancelOnError() the first one finishes the pool, and with
+// WithErrorThreshold() the one reaching the threshold does.
+func (p *dynamicPool) AddErr(f func() error) {
+	p.Add(func() {
+		err := f()
+		n := p.errs.add(err)
+		switch {
+		case err == nil:
+		case p.cfg.cancelOnErr:
+			p.forceFinish(err)
+		case n == p.cfg.errThreshold:
+			p.forceFinish(p.errs.first(n))
+		}
+	})
+}
+
+// Errors returns every non-nil error returned by the jobs from AddErr() and AddRetryIf(), in
+// the order they were returned. It is meant to be called after Wait(), before then it only
+// has the errors of the jobs completed so far.
+func (p *dynamicPool) Errors() []error {
+	return p.errs.list()
+}
+
+// AddIdempotent adds a new job like Add() that is ran a second time if it panics, in case
+// the panic was caused by a passing condition. Only a panic from the second run is given to
+// the panic handler.
+//
+//	f must be safe to run twice, including after being stopped part way through.
+func (p *dynamicPool) AddIdempotent(f func()) {
+	p.Add(retryOnPanic(f))
+}
+
+// ForceFinish provides an easy method prevent any future Add() from executing and prevent
+// any waiting goroutines from AddNoWait() from starting
+func (p *dynamicPool) ForceFinish() {
+	p.forceFinish(nil)
+}
+
+// ForceFinished is ForceFinish() returning true if this call finished the pool, and false if
+// the pool was already finished, such as by another call racing it, so only one caller acts
+// on the shutdown.
+func (p *dynamicPool) ForceFinished() bool {
+	_, first := p.forceFinish(nil)
+	return first
+}
+
+// ForceFinishN is ForceFinish() returning the number of jobs waiting on a free thread, which
+// will now never run. Calls after the first, or once the pool's context is done, return 0.
+//
+//	A job getting a thread at the very moment of the call may be counted even though it
+//	runs.
+func (p *dynamicPool) ForceFinishN() int {
+	n, _ := p.forceFinish(nil)
+	return n
+}
+
+// forceFinish is ForceFinishN() with cause given as the cause of the context being done. It
+// also returns true if this call finished the pool.
+func (p *dynamicPool) forceFinish(cause error) (int, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.ctx().Err() != nil {
+		return 0, false
+	}
+	n := int(atomic.LoadInt64(&p.waiting))
+	p.cancel(cause)
+	return n, true
+}
+
+// Drain stops any more jobs from being added while letting the jobs already added, running
+// or waiting on a thread, complete, for a graceful shutdown. Unlike ForceFinish() the
+// context is left alone, so IsDone() stays false while Draining() is true. A drained pool can
+// only be reopened with Reopen() once it is also finished with ForceFinish().
+func (p *dynamicPool) Drain() {
+	atomic.StoreInt32(&p.draining, 1)
+}
+
+// CommitRemaining is Drain() for a pool without totalJobs, so it always returns 0. It is
+// there so code can be written for either kind of pool.
+func (p *dynamicPool) CommitRemaining() int {
+	p.Drain()
+	return 0
+}
+
+// Close lets the jobs already added complete, like Drain() followed by Wait(), and then
+// finishes the pool so none of its goroutines are left behind. Err() then returns
+// ErrPoolClosed, which AddOrErr() rejects any more jobs with. It is safe to call more than
+// once, but not from within one of the pool's jobs as it would wait on itself.
+func (p *dynamicPool) Close() error {
+	p.Drain()
+	p.Wait()
+	p.forceFinish(ErrPoolClosed)
+	return nil
+}
+
+// Draining returns true once Drain() has been called.
+func (p *dynamicPool) Draining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
+}
+
+// Wait when called will block until all threads are completed. Note the pool will not be
+// finished until all jobs have been queued and finished.
+//
+//	Any number of goroutines may wait at once, even while jobs are being added or the
+//	pool is being finished.
+//
+// The pool is not used up by Wait(), so a long lived pool can keep being given jobs with Wait()
+// called at checkpoints. Wait() returns as soon as there are no jobs outstanding, so a job
+// added by another goroutine just after is not waited on; use Barrier() to wait on only the
+// jobs added before a checkpoint while others keep being added.
+func (p *dynamicPool) Wait() {
+	p.wg.Wait()
+}
+
+// WaitErr is Wait() returning the cause of the pool's context being done, as given by Err(),
+// or nil if it is not done. Cancelling the parent context, such as the one from an errgroup,
+// stops the pool like ForceFinish() does and WaitErr() returns the parent's cause.
+func (p *dynamicPool) WaitErr() error {
+	p.Wait()
+	return p.Err()
+}
+
+// WaitN blocks until at least n jobs have completed, counting every job completed since the
+// pool was created as Stats() does, and then returns without waiting for the rest. It also
+// returns once the pool is finished, so ForceFinish() releases it if fewer than n jobs will
+// ever complete.
+func (p *dynamicPool) WaitN(n int) {
+	p.completed.wait(p.ctx(), int64(n))
+}
+
+// WaitUntil blocks until pred returns true for the pool's Stats(), which are checked again
+// each time a job starts or completes, and returns true. It returns false once the pool is
+// finished, such as by ForceFinish(), so a pred that never becomes true does not block
+// forever. pred is not called concurrently and must not block.
+func (p *dynamicPool) WaitUntil(pred func(Stats) bool) bool {
+	return p.completed.until(p.ctx(), func() bool { return pred(p.Stats()) })
+}
+
+// WaitCtx is Wait() giving up once ctx is done, returning ctx's error then and nil if all
+// jobs completed first. Giving up does not stop any jobs, the pool carries on regardless.
+// A nil ctx is treated as context.Background().
+func (p *dynamicPool) WaitCtx(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-p.wg.done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitTimeout is Wait() giving up after d. It returns true if all jobs completed in time and
+// false otherwise, without stopping any jobs.
+func (p *dynamicPool) WaitTimeout(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-p.wg.done():
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// WaitHeartbeat is Wait() calling beat with the pool's Stats() every interval until all jobs
+// have completed, to show the pool is alive during a long batch. beat is not called once Wait()
+// would return, and is called from the waiting goroutine so it never runs concurrently.
+func (p *dynamicPool) WaitHeartbeat(every time.Duration, beat func(Stats)) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	done := p.wg.done()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		// the pool may have drained while the tick was waiting
+		select {
+		case <-done:
+			return
+		default:
+		}
+		beat(p.Stats())
+	}
+}
+
+// Pause stops any more jobs from starting until Resume() is called. Jobs already running
+// are not affected.
+//
+//	While paused Add() blocks, unless there is room in the buffer given by
+//	WithPauseBuffer(), and the goroutines from AddNoWait() wait.
+func (p *dynamicPool) Pause() {
+	p.pause.pause()
+}
+
+// Resume lets jobs start again after Pause(), including any buffered while paused.
+func (p *dynamicPool) Resume() {
+	p.pause.resume()
+}
+
+// Then adds f as a job that starts once every job added before the call to Then() has
+// completed, without blocking. f may add more jobs, so work can be done in phases on one
+// pool. Jobs added after the call are not waited on and may run before f, and Wait() waits
+// for f.
+func (p *dynamicPool) Then(f func()) {
+	if p.Draining() {
+		return
+	}
+
+	p.wg.Add(1)
+	wait := p.jobs.barrier()
+	e := p.jobs.join()
+	job := p.job(f, "")
+
+	atomic.AddInt64(&p.spawned, 1)
+	go func() {
+		wait()
+		if !p.acquireJob() {
+			p.jobs.leave(e)
+			p.wg.Done()
+			return
+		}
+		runJob(job, func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	}()
+}
+
+// Handoff hands the thread of the calling job to f, which is ran as soon as the calling job
+// returns without the thread going back to the pool, so no other job can take it first. It
+// returns false without running f if the pool was not created with WithNestedJobs() or if
+// not called from one of the pool's jobs.
+//
+//	Jobs handed off by one job run one after another in the order they were handed off.
+//	If the pool's context is done by the time the calling job returns f is not ran.
+func (p *dynamicPool) Handoff(f func()) bool {
+	if !p.workers.current() || p.Draining() {
+		return false
+	}
+
+	p.wg.Add(1)
+	e := p.jobs.join()
+	job := p.job(f, "")
+	p.workers.handoff(func() {
+		if p.ctx().Err() == nil {
+			job()
+		}
+		p.jobs.leave(e)
+		p.wg.Done()
+	})
+	return true
+}
+
+// Barrier returns a function that when called will block until all jobs added before
+// the call to Barrier() are completed. Jobs added after the call are not waited on, so
+// unlike Wait() it can be used on a pool that is still being added to.
+func (p *dynamicPool) Barrier() func() {
+	return p.jobs.barrier()
+}
+
+// DrainProgress returns a channel that receives the number of jobs still to complete each
+// time a job completes, and is closed once all jobs have completed. This makes it easy to
+// report progress while waiting on a pool that is no longer being added to.
+//
+//	Only the jobs added before the call are given room in the channel, so jobs added
+//	afterwards may not be reported. If no jobs are running the channel is already closed.
+func (p *dynamicPool) DrainProgress() <-chan int {
+	return p.jobs.drain()
+}
+
+// Acquire blocks until a thread is free and takes it for the caller, using the pool as a
+// semaphore for code that does not fit in a job. Every successful Acquire() must be
+// followed by a Release(). ctx's error is returned if ctx is done first, and ErrPoolClosed if
+// the pool is finished first, without taking a thread. A nil ctx is treated as
+// context.Background().
+func (p *dynamicPool) Acquire(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if p.ctx().Err() != nil {
+		return ErrPoolClosed
+	}
+
+	if err := takeThread(&p.state, ctx); err != nil {
+		return err
+	}
+	if p.ctx().Err() != nil {
+		p.giveBack()
+		return ErrPoolClosed
+	}
+	atomic.AddInt64(&p.acquired, 1)
+	return nil
+}
+
+// Release frees a thread taken by Acquire(). It panics if there is no such thread to free,
+// as that would let more than the pool's threads run at once.
+func (p *dynamicPool) Release() {
+	for {
+		n := atomic.LoadInt64(&p.acquired)
+		if n == 0 {
+			panic("threadpool: Release called without a matching Acquire")
+		}
+		if atomic.CompareAndSwapInt64(&p.acquired, n, n-1) {
+			break
+		}
+	}
+	p.giveBack()
+}
+
+// ReserveSlots blocks until n threads are free and reserves them for the returned Reservation.
+// Jobs added through the Reservation start immediately. Unused threads must be given back
+// with Release().
+//
+//	Only one reservation is made at a time, so two callers can never each hold part of
+//	the threads the other is waiting on.
+//
+// If n is more than Concurrency() ErrReserveCapacity is returned. If the context is done
+// before all n threads are free its error is returned.
+func (p *dynamicPool) ReserveSlots(n int) (*Reservation, error) {
+	if n < 0 || n > p.Concurrency() {
+		return nil, ErrReserveCapacity
+	}
+
+	p.reserveMux.Lock()
+	defer p.reserveMux.Unlock()
+
+	for i := 0; i < n; i++ {
+		if !p.acquire() {
+			for ; i > 0; i-- {
+				p.releaseThread()
+			}
+			return nil, p.ctx().Err()
+		}
+		atomic.AddInt64(&p.reserved, 1)
+	}
+	return &Reservation{pool: p, slots: n}, nil
+}
+
+// AddGroup adds the jobs in fs so that they all start at the same moment. It blocks until a
+// thread is free for every job, using ReserveSlots(), and then lets them all run at once.
+// This is useful for measuring how jobs behave when contending with each other.
+//
+// The errors are the same as ReserveSlots() with n of len(fs).
+func (p *dynamicPool) AddGroup(fs []func()) error {
+	return addGroup(p, fs)
+}
+
+// AddWeighted adds a new job that takes weight threads rather than one, for jobs heavy enough
+// to count as several, such as ones using a lot of memory. Like ReserveSlots() it blocks
+// until weight threads are free, and they are all freed once f returns.
+//
+// ErrWeight is returned for a weight of less than 1, and ErrReserveCapacity for one more than
+// Concurrency(), as it could never run. If the context is done first its error is
+// returned, and ErrPoolClosed if the pool will not run any more jobs.
+func (p *dynamicPool) AddWeighted(f func(), weight int) error {
+	return addWeighted(p, f, weight)
+}
+
+func (p *dynamicPool) startReserved(f func()) bool {
+	if p.ctx().Err() != nil || p.Draining() {
+		return false
+	}
+	p.wg.Add(1)
+	e := p.jobs.join()
+	atomic.AddInt64(&p.reserved, -1)
+	atomic.AddInt64(&p.spawned, 1)
+	go runJob(p.job(f, ""), func() { p.done(e) }, p.cfg.slotReclaim, &p.leaked)
+	return true
+}
+
+func (p *dynamicPool) releaseThread() {
+	atomic.AddInt64(&p.reserved, -1)
+	p.giveBack()
+}
+
+// held reports whether any thread is taken by Acquire() or a Reservation. Such a thread would
+// be given back to the new threads of Reset() or Reopen() and overfill them.
+func (p *dynamicPool) held() bool {
+	return atomic.LoadInt64(&p.acquired) > 0 || atomic.LoadInt64(&p.reserved) > 0
+}
+
+// SetPanicHandler replaces the panic handler given by WithPanicHandlerCtx(). It is safe to call
+// while jobs are running, any panic recovered after it returns is given to handler. A nil
+// handler goes back to logging panics.
+func (p *dynamicPool) SetPanicHandler(handler func(ctx context.Context, recovered any, stack []byte)) {
+	p.panics.store(handler)
+}
+
+// PanickedJobs returns the jobs whose panic was recovered since the last call, so they can
+// be looked at or added again.
+//
+//	The pool keeps a reference to each of these jobs, and whatever they hold on to,
+//	until PanickedJobs() is called. Call it regularly if many jobs may panic.
+func (p *dynamicPool) PanickedJobs() []func() {
+	return p.panics.take()
+}
+
+// QueueLatencyPercentiles returns estimated percentiles of how long jobs waited for a free
+// thread after being added. The estimate is made from a random sample of the jobs.
+func (p *dynamicPool) QueueLatencyPercentiles() LatencyPercentiles {
+	return p.latency.percentiles()
+}
+
+// AddLabeled adds a new job like Add() and records how long it ran under label, to be
+// reported by LatencyByLabel().
+func (p *dynamicPool) AddLabeled(label string, f func()) {
+	p.add(func() {
+		start := time.Now()
+		defer func() { p.latency.addLabeled(label, time.Since(start)) }()
+		f()
+	}, label)
+}
+
+// AddIfFresh adds a new job like Add() that only runs f if stillNeeded returns true once
+// the job has a thread, so work that went stale while waiting does not use up the thread.
+// A skipped job is counted by Dropped() as DropStale.
+func (p *dynamicPool) AddIfFresh(stillNeeded func() bool, f func()) {
+	p.Add(func() {
+		if !stillNeeded() {
+			p.drops.add(DropStale)
+			return
+		}
+		f()
+	})
+}
+
+// AddCtx adds a new job like Add() for f, which is given a context that is done once either
+// the pool's context or ctx is done, so a single job can be cancelled. If ctx is already done
+// by the time the job has a thread f is not called, and Dropped() counts it as DropExpired.
+// A nil ctx is treated as context.Background().
+func (p *dynamicPool) AddCtx(ctx context.Context, f func(context.Context)) {
+	p.Add(ctxJob(p.ctx(), ctx, f, &p.drops))
+}
+
+// AddWithValues adds a new job like Add() for f, which is given a context carrying the values
+// of ctx, such as trace IDs, but done only once the pool's context is. Unlike AddCtx()
+// cancelling ctx does not stop the job. A nil ctx is treated as context.Background().
+func (p *dynamicPool) AddWithValues(ctx context.Context, f func(context.Context)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p.Add(ctxJob(p.ctx(), context.WithoutCancel(ctx), f, &p.drops))
+}
+
+// Dropped returns the number of jobs added but not ran for each reason, DropStale,
+// DropExpired or DropMaxDepth.
+func (p *dynamicPool) Dropped() map[string]int64 {
+	return p.drops.counts()
+}
+
+// LatencyByLabel returns the stats of how long the completed jobs from AddLabeled() ran,
+// for each label. The mean is over every job while P95 is estimated from a random sample.
+func (p *dynamicPool) LatencyByLabel() map[string]LatencyStats {
+	return p.latency.byLabel()
+}
+
+// LeakedGoroutines returns the number of jobs still running after their thread was
+// reclaimed by WithSlotReclaim().
+func (p *dynamicPool) LeakedGoroutines() int64 {
+	return atomic.LoadInt64(&p.leaked)
+}
+
+// GoroutinesSpawned returns the number of goroutines the pool has started to run or wait
+// on jobs. Every job is given its own goroutine, apart from jobs ran inline by Add() or
+// handed off with Handoff().
+func (p *dynamicPool) GoroutinesSpawned() int64 {
+	return atomic.LoadInt64(&p.spawned)
+}
+
+// Err returns nil until the pool's context is done and then returns the cause:
+// context.Canceled after ForceFinish(), ErrMaxLifetime after the duration given to
+// WithMaxLifetime(), or the cause of the parent context being done.
+func (p *dynamicPool) Err() error {
+	return context.Cause(p.ctx())
+}
+
+// finished returns a channel closed once the pool will not run any more jobs.
+func (p *dynamicPool) finished() <-chan struct{} {
+	return p.ctx().Done()
+}
+
+// Concurrency returns the number of jobs ran at once. It is the concurrentThreads the pool
+// was created with, after defaults were applied, unless changed by Resize().
+func (p *dynamicPool) Concurrency() int {
+	return int(atomic.LoadInt64(&p.limit))
+}
+
+// Total returns -1, as the pool does not have a fixed number of jobs.
+func (p *dynamicPool) Total() int {
+	return -1
+}
+
+// String describes the pool and what it is doing for logs and debugging, using its name from
+// WithName(). It is a snapshot like Stats() and as cheap.
+func (p *dynamicPool) String() string {
+	return describe(p.cfg.name, p.Kind(), p.Concurrency(), p.Stats())
+}
+
+// Config returns the settings the pool was created with, after defaults were applied.
+func (p *dynamicPool) Config() Config {
+	return p.cfg.export()
+}
+
+// Clone creates a new pool with the same settings as p, given by Config(), but none of its
+// jobs or state. The new pool uses ctx in place of p's context. A nil ctx is treated as
+// context.Background().
+//
+//	The panic handler is the one given by WithPanicHandlerCtx(), not any set later with
+//	SetPanicHandler(). A source given by WithRandSource() is not shared, the new pool is
+//	given a new source seeded from it.
+func (p *dynamicPool) Clone(ctx context.Context) Pool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return newDynamicPool(ctx, p.latency.clone(p.cfg))
+}
+
+// Reopen lets the pool run jobs again after ForceFinish() by giving it a new context from the
+// one it was created with. The options are applied afresh, so WithRampUp() ramps up again
+// and WithMaxLifetime() restarts. It does nothing if the pool is not finished.
+//
+//	ErrJobsInFlight is returned while any job is still added or running, or a thread
+//	taken by Acquire() or ReserveSlots() is not released, and the parent context's error
+//	if it is done. Reopen must not be called while jobs are being added.
+func (p *dynamicPool) Reopen() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.ctx().Err() == nil {
+		return nil
+	}
+	if p.jobs.active() || p.held() {
+		return ErrJobsInFlight
+	}
+	if p.parent.Err() != nil {
+		return context.Cause(p.parent)
+	}
+	p.open()
+	return nil
+}
+
+// Reset gives the pool a new context from the one it was created with and a new set of
+// threads, as if it was just created. Unlike Reopen() it also works on a pool that is not
+// finished, finishing the old context first.
+//
+//	ErrJobsInFlight is returned while any job is still added or running, or a thread
+//	taken by Acquire() or ReserveSlots() is not released, and the parent context's error
+//	if it is done. Reset must not be called while jobs are being added.
+func (p *dynamicPool) Reset() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.jobs.active() || p.held() {
+		return ErrJobsInFlight
+	}
+	if p.parent.Err() != nil {
+		return context.Cause(p.parent)
+	}
+	p.cancel(nil)
+	p.open()
+	return nil
+}
+
+// Resize changes the number of jobs ran at once to n, while jobs are running. Growing frees
+// the extra threads at once. Shrinking takes free threads at once and the rest as running
+// jobs complete, so more than n jobs may run until then.
+//
+//	n may be more than the concurrentThreads the pool was created with, so the pool can
+//	grow as well as shrink. n must be at least 1, otherwise ErrResize is returned and
+//	nothing is changed. Config() keeps reporting the concurrentThreads the pool was
+//	created with, Concurrency() reports n.
+func (p *dynamicPool) Resize(n int) error {
+	if n <= 0 {
+		return ErrResize
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if n > cap(p.threads()) {
+		p.grow(n)
+	}
+
+	diff := n - int(atomic.LoadInt64(&p.limit))
+	atomic.StoreInt64(&p.limit, int64(n))
+	for ; diff < 0; diff++ {
+		select {
+		case <-p.threads():
+		default:
+			atomic.AddInt64(&p.owed, 1)
+		}
+	}
+	for ; diff > 0; diff-- {
+		p.giveBack()
+	}
+	return nil
+}
+
+// giveBack returns a thread to the pool, unless it is owed to a Resize() shrinking the pool.
+func (p *dynamicPool) giveBack() {
+	p.giveBackFrom(nil)
+}
+
+// giveBackFrom is giveBack() for a thread taken while the pool's context was ctx. It is
+// dropped if Reset() or Reopen() have given the pool new threads since. A nil ctx matches any.
+func (p *dynamicPool) giveBackFrom(ctx context.Context) {
+	p.stateMux.RLock()
+	defer p.stateMux.RUnlock()
+
+	st := p.state.Load()
+	if ctx != nil && st.ctx != ctx {
+		return
+	}
+	if p.cfg.stallDetect > 0 {
+		p.stuck.threadFreed()
+	}
+	for {
+		owed := atomic.LoadInt64(&p.owed)
+		if owed == 0 {
+			st.c <- true
+			return
+		}
+		if atomic.CompareAndSwapInt64(&p.owed, owed, owed-1) {
+			return
+		}
+	}
+}
+
+// grow replaces the pool's threads with a channel with room for n, moving the free threads
+// over. Jobs waiting on the old channel are woken to wait on the new one.
+func (p *dynamicPool) grow(n int) {
+	p.stateMux.Lock()
+	defer p.stateMux.Unlock()
+
+	old := p.state.Load()
+	c := make(chan bool, n)
+	for moved := false; !moved; {
+		select {
+		case t := <-old.c:
+			c <- t
+		default:
+			moved = true
+		}
+	}
+	p.state.Store(&poolState{ctx: old.ctx, cancel: old.cancel, c: c, replaced: make(chan struct{}), lifetime: old.lifetime})
+	close(old.replaced)
+}
+
+// Stats returns a snapshot of what the pool is doing. It is cheap enough to poll often, and
+// takes no locks.
+func (p *dynamicPool) Stats() Stats {
+	available := len(p.threads())
+	return Stats{
+		Running:       int(atomic.LoadInt64(&p.limit)+atomic.LoadInt64(&p.owed)) - available,
+		Available:     available,
+		Completed:     p.completed.count(),
+		Panicked:      atomic.LoadInt64(&p.panics.count),
+		Waiting:       int(atomic.LoadInt64(&p.waiting)),
+		WaitCount:     atomic.LoadInt64(&p.waitCount),
+		TotalWaitTime: time.Duration(atomic.LoadInt64(&p.waitNanos)),
+	}
+}
+
+// Kind returns Dynamic.
+func (p *dynamicPool) Kind() PoolKind {
+	return Dynamic
+}
+
+// IsDone will return the status of the context if it is Done. A value true indicates the pool
+// will not start any more jobs.
+func (p *dynamicPool) IsDone() bool {
+	select {
+	case <-p.ctx().Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// fillThreads makes all the threads of c free. If rampUp is >0 one thread is free at once and
+// the rest are freed with giveBack at even intervals over rampUp.
+func fillThreads(ctx context.Context, c chan bool, rampUp time.Duration, giveBack func()) {
+	n := cap(c)
+	if rampUp <= 0 || n == 1 {
+		for i := 0; i < n; i++ {
+			c <- true
+		}
+		return
+	}
+
+	c <- true
+	go func() {
+		t := time.NewTicker(rampUp / time.Duration(n-1))
+		defer t.Stop()
+
+		for i := 1; i < n; i++ {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+			giveBack()
+		}
+	}()
+}
+
+// takeThread blocks until one of the pool's free threads can be taken and returns nil. It
+// returns ctx's error if ctx is done first, and ErrPoolClosed if the pool's context is. If
+// Resize() replaces the threads while waiting, it waits on the new ones.
+func takeThread(state *atomic.Pointer[poolState], ctx context.Context) error {
+	for {
+		st := state.Load()
+		select {
+		case <-st.c:
+			return nil
+		case <-st.replaced:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-st.ctx.Done():
+			return ErrPoolClosed
+		}
+	}
+}
+
+// runJob calls f and then release. If reclaimAfter is >0 and f has not returned by then,
+// release is called early and leaked counts f as running until it returns.
+func runJob(f, release func(), reclaimAfter time.Duration, leaked *int64) {
+	if reclaimAfter <= 0 {
+		f()
+		release()
+		return
+	}
+
+	var released int32
+	t := time.AfterFunc(reclaimAfter, func() {
+		atomic.AddInt64(leaked, 1)
+		if !atomic.CompareAndSwapInt32(&released, 0, 1) {
+			atomic.AddInt64(leaked, -1)
+			return
+		}
+		release()
+	})
+	f()
+	t.Stop()
+	if atomic.CompareAndSwapInt32(&released, 0, 1) {
+		release()
+		return
+	}
+	atomic.AddInt64(leaked, -1)
+}
+
+// panicHandler holds the handler given recovered panics. It can be replaced while jobs run.
+// It also keeps the jobs that panicked until they are taken by PanickedJobs().
+type panicHandler struct {
+	v        atomic.Value
+	mux      sync.Mutex
+	panicked []func()
+	count    int64 // every panic recovered, not reset by take()
+}
+
+type panicHandlerFunc func(ctx context.Context, recovered any, stack []byte)
+
+func (h *panicHandler) store(handler panicHandlerFunc) {
+	h.v.Store(handler)
+}
+
+func (h *panicHandler) load() panicHandlerFunc {
+	handler, _ := h.v.Load().(panicHandlerFunc)
+	return handler
+}
+
+// guard returns f wrapped to recover a panic and pass it to the current handler, or to
+// logPanic() if there is none, so a panicking job still gives back its thread.
+func (h *panicHandler) guard(ctx context.Context, f func()) func() {
+	return func() {
+		defer func() {
+			if r := recover(); r != nil {
+				h.mux.Lock()
+				h.panicked = append(h.panicked, f)
+				h.mux.Unlock()
+				atomic.AddInt64(&h.count, 1)
+
+				handler := h.load()
+				if handler == nil {
+					handler = logPanic
+				}
+				handler(ctx, r, debug.Stack())
+			}
+		}()
+		f()
+	}
+}
+
+// logPanic is the panic handler used when none is given.
+func logPanic(_ context.Context, recovered any, stack []byte) {
+	log.Printf("threadpool: recovered panic from job: %v\n%s", recovered, stack)
+}
+
+// take returns the jobs that panicked and forgets them.
+func (h *panicHandler) take() []func() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	jobs := h.panicked
+	h.panicked = nil
+	return jobs
+}
+
+// pauser holds jobs back from starting while paused.
+type pauser struct {
+	mux      sync.Mutex
+	resumed  chan struct{} // nil unless paused
+	size     int
+	buffered int
+}
+
+func (g *pauser) pause() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauser) resume() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+		g.buffered = 0
+	}
+}
+
+// wait returns a channel closed on resume, or nil if not paused.
+func (g *pauser) wait() <-chan struct{} {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	return g.resumed
+}
+
+// buffer returns true if paused and a job can be taken into the buffer.
+func (g *pauser) buffer() bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.resumed == nil || g.buffered >= g.size {
+		return false
+	}
+	g.buffered++
+	return true
+}
+
+// workers keeps track of the goroutines running jobs and how deeply each job is nested, so a
+// job adding to its own pool can be spotted. Nothing is tracked unless tracked is set, as
+// finding the goroutine is costly.
+type workers struct {
+	tracked bool
+	ids     sync.Map
+}
+
+// worker is the job running on a goroutine. handoffs is only used by that goroutine.
+type worker struct {
+	depth    int
+	handoffs []func()
+}
+
+// run returns f wrapped to mark its goroutine as a worker at depth while it runs, followed by
+// any jobs handed off by f. A job ran inline by another job restores the outer job when done.
+func (w *workers) run(f func(), depth int) func() {
+	if !w.tracked {
+		return f
+	}
+	return func() {
+		id := goid()
+		current := &worker{depth: depth}
+		outer, nested := w.ids.Load(id)
+		w.ids.Store(id, current)
+		defer func() {
+			if nested {
+				w.ids.Store(id, outer)
+			} else {
+				w.ids.Delete(id)
+			}
+		}()
+		f()
+		for len(current.handoffs) > 0 {
+			next := current.handoffs[0]
+			current.handoffs = current.handoffs[1:]
+			next()
+		}
+	}
+}
+
+// current returns true if called from a goroutine running a job, and always false if jobs
+// are not tracked.
+func (w *workers) current() bool {
+	if !w.tracked {
+		return false
+	}
+	_, ok := w.ids.Load(goid())
+	return ok
+}
+
+// depth returns the depth of the job running on the calling goroutine, or 0 if none is.
+func (w *workers) depth() int {
+	if !w.tracked {
+		return 0
+	}
+	cw, ok := w.ids.Load(goid())
+	if !ok {
+		return 0
+	}
+	return cw.(*worker).depth
+}
+
+// handoff queues f to run on the calling goroutine once its current job returns. It must be
+// called from a goroutine running a job.
+func (w *workers) handoff(f func()) {
+	cw, _ := w.ids.Load(goid())
+	cw.(*worker).handoffs = append(cw.(*worker).handoffs, f)
+}
+
+// goid returns the id of the calling goroutine, taken from the first line of its stack trace.
+func goid() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// onceKeys keeps track of the keys given to AddOnce().
+type onceKeys struct {
+	mux  sync.Mutex
+	keys map[string]bool
+}
+
+// first returns true only for the first call with key.
+func (o *onceKeys) first(key string) bool {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if o.keys[key] {
+		return false
+	}
+	if o.keys == nil {
+		o.keys = make(map[string]bool)
+	}
+	o.keys[key] = true
+	return true
+}
+
+// tracker keeps track of the jobs that have been added but not yet completed.
+//
+//	For Barrier() jobs are grouped into epochs, each ended by a call to Barrier(). An
+//	epoch is finished once all of its jobs and all earlier epochs are finished.
+type tracker struct {
+	mux     sync.Mutex
+	current *epoch
+	pending int
+	drains  []chan int
+}
+
+type epoch struct {
+	jobs   int
+	sealed bool
+	prev   *epoch // nil once all earlier epochs are finished
+	next   *epoch
+	done   chan struct{}
+}
+
+// join adds a job to the current epoch. The returned epoch must be given to leave()
+// once the job is completed or cancelled.
+func (t *tracker) join() *epoch {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if t.current == nil {
+		t.current = &epoch{done: make(chan struct{})}
+	}
+	t.current.jobs++
+	t.pending++
+	return t.current
+}
+
+func (t *tracker) leave(e *epoch) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	e.jobs--
+	t.finish(e)
+
+	t.pending--
+	for _, c := range t.drains {
+		select {
+		case c <- t.pending:
+		default:
+		}
+		if t.pending == 0 {
+			close(c)
+		}
+	}
+	if t.pending == 0 {
+		t.drains = nil
+	}
+}
+
+// active returns true while any job has joined but not left.
+func (t *tracker) active() bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.pending > 0
+}
+
+// barrier seals the current epoch and returns a function waiting for it to finish.
+func (t *tracker) barrier() func() {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	e := t.current
+	if e == nil {
+		e = &epoch{done: make(chan struct{})}
+	}
+	e.sealed = true
+	t.current = &epoch{prev: e, done: make(chan struct{})}
+	e.next = t.current
+	t.finish(e)
+
+	return func() {
+		<-e.done
+	}
+}
+
+// finish closes e and any following epochs that are now finished. It expects t.mux to be held.
+func (t *tracker) finish(e *epoch) {
+	for e != nil && e.sealed && e.jobs == 0 && e.prev == nil {
+		close(e.done)
+		if e.next != nil {
+			e.next.prev = nil
+		}
+		e = e.next
+	}
+}
+
+// drain returns a channel receiving the number of pending jobs each time a job completes.
+// The channel is closed once no jobs are pending.
+func (t *tracker) drain() <-chan int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	// one slot per pending job so a completing job never blocks
+	c := make(chan int, t.pending)
+	if t.pending == 0 {
+		close(c)
+		return c
+	}
+	t.drains = append(t.drains, c)
+	return c
+}
@@ -1,10 +1,18 @@
 package threadpool
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log"
 	"math"
+	"math/rand"
+	"os"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -13,7 +21,7 @@ func TestPool_Add(t *testing.T) {
 	total := 16
 	concur := 3
 
-	h := New(context.Background(), concur, total)
+	h := NewFixedSize(context.Background(), concur, total)
 
 	start := time.Now()
 	for i := 0; i < total; i++ {
@@ -34,7 +42,7 @@ func TestPool_AddNoWait(t *testing.T) {
 	total := 16
 	concur := 3
 
-	h := New(context.Background(), concur, total)
+	h := NewFixedSize(context.Background(), concur, total)
 
 	start := time.Now()
 	for i := 0; i < total; i++ {
@@ -55,7 +63,7 @@ func TestPool_AddNoWait2(t *testing.T) {
 	total := runtime.NumCPU() * 3
 	concur := -1
 
-	h := New(context.Background(), concur, total)
+	h := NewFixedSize(context.Background(), concur, total)
 
 	actual := 0
 	mut := sync.Mutex{}
@@ -78,7 +86,7 @@ func TestPool_AddNoWait2(t *testing.T) {
 func TestPool_MultiThreadAdd(t *testing.T) {
 	threadAmount := 10
 	threadCount := 10
-	h := New(context.Background(), 0, threadCount*threadAmount)
+	h := NewFixedSize(context.Background(), 0, threadCount*threadAmount)
 
 	for i := 0; i < threadCount; i++ {
 		t.Logf("creating thread: %v", i)
@@ -100,7 +108,7 @@ func TestCtxAware(t *testing.T) {
 	concur := 1
 	ctx, cancel := context.WithCancel(context.Background())
 
-	h := New(ctx, concur, total)
+	h := NewFixedSize(ctx, concur, total)
 
 	go func() {
 		time.Sleep(3 * time.Second)
@@ -126,3 +134,2548 @@ Loop:
 	h.Wait()
 	//if we finish before the test time we're good
 }
+
+func TestPool_AddOnce(t *testing.T) {
+	total := 100
+
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, total)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+
+	for name, h := range pools {
+		var runs int32
+		start := sync.WaitGroup{}
+		start.Add(1)
+		submitted := sync.WaitGroup{}
+		for i := 0; i < total; i++ {
+			submitted.Add(1)
+			go func() {
+				defer submitted.Done()
+				start.Wait()
+				h.AddOnce("init", func() {
+					atomic.AddInt32(&runs, 1)
+					time.Sleep(10 * time.Millisecond)
+				})
+			}()
+		}
+		start.Done()
+		submitted.Wait()
+		h.Wait()
+
+		if runs != 1 {
+			t.Fatalf("%v: expected %v but found %v", name, 1, runs)
+		}
+	}
+}
+
+func TestPool_Kind(t *testing.T) {
+	if k := NewFixedSize(context.Background(), 1, 0).Kind(); k != Fixed {
+		t.Fatalf("expected %v but found %v", Fixed, k)
+	}
+	if k := New(context.Background(), 1).Kind(); k != Dynamic {
+		t.Fatalf("expected %v but found %v", Dynamic, k)
+	}
+
+	q := NewPersistent(context.Background(), 1)
+	defer q.(*QueuePool).Close()
+	if k := q.Kind(); k != Queue {
+		t.Fatalf("expected %v but found %v", Queue, k)
+	}
+	pq := NewPriorityPool(context.Background(), 1)
+	defer pq.Close()
+	if k := pq.Kind(); k != Priority {
+		t.Fatalf("expected %v but found %v", Priority, k)
+	}
+}
+
+func TestPool_Barrier(t *testing.T) {
+	h := full(New(context.Background(), 8))
+
+	finished := func(wait func()) bool {
+		done := make(chan bool)
+		go func() {
+			wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+
+	gateA := make(chan bool)
+	for i := 0; i < 3; i++ {
+		h.Add(func() { <-gateA })
+	}
+	first := h.Barrier()
+
+	gateB := make(chan bool)
+	for i := 0; i < 2; i++ {
+		h.AddNoWait(func() { <-gateB })
+	}
+	second := h.Barrier()
+
+	gateC := make(chan bool)
+	h.Add(func() { <-gateC })
+
+	close(gateB)
+	if finished(first) {
+		t.Fatalf("expected first barrier to wait on its jobs")
+	}
+	if finished(second) {
+		t.Fatalf("expected second barrier to wait on the first barrier's jobs")
+	}
+
+	close(gateA)
+	if !finished(first) {
+		t.Fatalf("expected first barrier to finish")
+	}
+	if !finished(second) {
+		t.Fatalf("expected second barrier to finish without waiting on later jobs")
+	}
+
+	close(gateC)
+	h.Wait()
+	if !finished(h.Barrier()) {
+		t.Fatalf("expected barrier on an idle pool to finish")
+	}
+}
+
+func TestPool_WithSlotReclaim(t *testing.T) {
+	h := full(New(context.Background(), 1, WithSlotReclaim(50*time.Millisecond)))
+
+	stuck := make(chan bool)
+	h.Add(func() {
+		<-stuck // ignores any cancellation
+	})
+
+	ran := make(chan bool)
+	h.Add(func() {
+		close(ran)
+	})
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the stuck job's thread to be reclaimed")
+	}
+	h.Wait()
+
+	if leaked := h.LeakedGoroutines(); leaked != 1 {
+		t.Fatalf("expected %v but found %v", 1, leaked)
+	}
+
+	close(stuck)
+	for start := time.Now(); h.LeakedGoroutines() != 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected %v but found %v", 0, h.LeakedGoroutines())
+		}
+	}
+}
+
+func TestPool_WithPanicHandlerCtx(t *testing.T) {
+	var h Pool
+	var handled int32
+	h = New(context.Background(), 1, WithPanicHandlerCtx(func(ctx context.Context, recovered any, stack []byte) {
+		atomic.AddInt32(&handled, 1)
+		if recovered != "boom" {
+			t.Errorf("expected %v but found %v", "boom", recovered)
+		}
+		if len(stack) == 0 {
+			t.Errorf("expected a stack trace")
+		}
+		if ctx.Err() == nil {
+			h.ForceFinish()
+		}
+	}))
+
+	gate := make(chan bool)
+	h.Add(func() {
+		<-gate
+		panic("boom")
+	})
+
+	var ran int32
+	for i := 0; i < 10; i++ {
+		h.AddNoWait(func() {
+			atomic.AddInt32(&ran, 1)
+		})
+	}
+	close(gate)
+	h.Wait()
+
+	if handled != 1 {
+		t.Fatalf("expected %v but found %v", 1, handled)
+	}
+	if ran != 0 {
+		t.Fatalf("expected %v but found %v", 0, ran)
+	}
+}
+
+func TestPool_DrainProgress(t *testing.T) {
+	total := 5
+	h := full(New(context.Background(), 2))
+
+	for i := 0; i < total; i++ {
+		d := time.Duration(i+1) * 10 * time.Millisecond
+		h.AddNoWait(func() {
+			time.Sleep(d)
+		})
+	}
+
+	var actual []int
+	for n := range h.DrainProgress() {
+		actual = append(actual, n)
+	}
+	h.Wait()
+
+	if len(actual) != total {
+		t.Fatalf("expected %v values but found %v", total, actual)
+	}
+	for i, n := range actual {
+		if n != total-1-i {
+			t.Fatalf("expected a descending sequence ending at 0 but found %v", actual)
+		}
+	}
+
+	if _, ok := <-h.DrainProgress(); ok {
+		t.Fatalf("expected a closed channel for an idle pool")
+	}
+}
+
+func TestPool_SetPanicHandler(t *testing.T) {
+	var before, after int32
+	h := full(New(context.Background(), 4, WithPanicHandlerCtx(func(ctx context.Context, recovered any, stack []byte) {
+		atomic.AddInt32(&before, 1)
+	})))
+
+	for i := 0; i < 100; i++ {
+		h.AddNoWait(func() {
+			time.Sleep(time.Millisecond)
+			panic("boom")
+		})
+		if i == 50 {
+			h.SetPanicHandler(func(ctx context.Context, recovered any, stack []byte) {
+				atomic.AddInt32(&after, 1)
+			})
+		}
+	}
+	h.Wait()
+
+	if total := atomic.LoadInt32(&before) + atomic.LoadInt32(&after); total != 100 {
+		t.Fatalf("expected %v but found %v", 100, total)
+	}
+
+	swapped := atomic.LoadInt32(&before)
+	for i := 0; i < 10; i++ {
+		h.Add(func() { panic("boom") })
+	}
+	h.Wait()
+
+	if actual := atomic.LoadInt32(&before); actual != swapped {
+		t.Fatalf("expected %v but found %v", swapped, actual)
+	}
+	if actual := atomic.LoadInt32(&after); actual != 100-swapped+10 {
+		t.Fatalf("expected %v but found %v", 100-swapped+10, actual)
+	}
+}
+
+func TestPool_QueueLatencyPercentiles(t *testing.T) {
+	h := full(New(context.Background(), 1))
+
+	for i := 0; i < 10; i++ {
+		h.AddNoWait(func() {
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+	h.Wait()
+
+	p := h.QueueLatencyPercentiles()
+	if p.P50 < 30*time.Millisecond || p.P90 < p.P50 || p.P99 < p.P90 {
+		t.Fatalf("expected ordered percentiles with a median of about 40ms but found %+v", p)
+	}
+	if p.P99 > time.Second {
+		t.Fatalf("expected a p99 of about 90ms but found %v", p.P99)
+	}
+}
+
+func TestPool_AddRetryIf(t *testing.T) {
+	errFatal := errors.New("fatal")
+	errTemporary := errors.New("temporary")
+	retryable := func(err error) bool {
+		return err == errTemporary
+	}
+
+	h := full(New(context.Background(), 2))
+
+	var fatalRuns, temporaryRuns int32
+	h.AddRetryIf(3, time.Millisecond, retryable, func() error {
+		atomic.AddInt32(&fatalRuns, 1)
+		return errFatal
+	})
+	h.AddRetryIf(3, time.Millisecond, retryable, func() error {
+		if atomic.AddInt32(&temporaryRuns, 1) == 1 {
+			return errTemporary
+		}
+		return nil
+	})
+	h.Wait()
+
+	if fatalRuns != 1 {
+		t.Fatalf("expected %v but found %v", 1, fatalRuns)
+	}
+	if temporaryRuns != 2 {
+		t.Fatalf("expected %v but found %v", 2, temporaryRuns)
+	}
+}
+
+func TestPool_NilContext(t *testing.T) {
+	var ctx context.Context
+
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(ctx, 2, 3)),
+		"dynamic": full(New(ctx, 2)),
+	}
+
+	for name, h := range pools {
+		var ran int32
+		for i := 0; i < 3; i++ {
+			h.Add(func() {
+				atomic.AddInt32(&ran, 1)
+			})
+		}
+		h.Wait()
+
+		if ran != 3 {
+			t.Fatalf("%v: expected %v but found %v", name, 3, ran)
+		}
+	}
+}
+
+func TestPool_WithPauseBuffer(t *testing.T) {
+	buffer := 3
+	h := full(New(context.Background(), 2, WithPauseBuffer(buffer)))
+
+	var ran int32
+	job := func() {
+		atomic.AddInt32(&ran, 1)
+	}
+
+	h.Pause()
+	for i := 0; i < buffer; i++ {
+		h.Add(job) // must not block
+	}
+
+	added := make(chan bool)
+	go func() {
+		h.Add(job)
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatalf("expected Add to block once the buffer is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if actual := atomic.LoadInt32(&ran); actual != 0 {
+		t.Fatalf("expected %v but found %v", 0, actual)
+	}
+
+	h.Resume()
+	<-added
+	h.Wait()
+
+	if ran != int32(buffer+1) {
+		t.Fatalf("expected %v but found %v", buffer+1, ran)
+	}
+}
+
+func TestPool_AddIdempotent(t *testing.T) {
+	var panics int32
+	h := full(New(context.Background(), 2, WithPanicHandlerCtx(func(ctx context.Context, recovered any, stack []byte) {
+		atomic.AddInt32(&panics, 1)
+	})))
+
+	var runs int32
+	h.AddIdempotent(func() {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			panic("transient")
+		}
+	})
+	h.Wait()
+
+	if runs != 2 {
+		t.Fatalf("expected %v but found %v", 2, runs)
+	}
+	if panics != 0 {
+		t.Fatalf("expected %v but found %v", 0, panics)
+	}
+
+	h.AddIdempotent(func() {
+		panic("permanent")
+	})
+	h.Wait()
+
+	if panics != 1 {
+		t.Fatalf("expected %v but found %v", 1, panics)
+	}
+}
+
+func TestPool_WithRampUp(t *testing.T) {
+	concur := 4
+	rampUp := 300 * time.Millisecond
+	h := New(context.Background(), concur, WithRampUp(rampUp))
+
+	var running, early, peak int32
+	start := time.Now()
+	for i := 0; i < 40; i++ {
+		h.AddNoWait(func() {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			if time.Since(start) < rampUp/time.Duration(2*(concur-1)) && n > 1 {
+				atomic.StoreInt32(&early, n)
+			}
+			time.Sleep(20 * time.Millisecond)
+		})
+	}
+	h.Wait()
+
+	if early != 0 {
+		t.Fatalf("expected %v job running at the start but found %v", 1, early)
+	}
+	if peak != int32(concur) {
+		t.Fatalf("expected %v but found %v", concur, peak)
+	}
+}
+
+func TestPool_PanickedJobs(t *testing.T) {
+	h := full(New(context.Background(), 4, WithPanicHandlerCtx(func(ctx context.Context, recovered any, stack []byte) {})))
+
+	mux := sync.Mutex{}
+	runs := map[int]int{}
+	for i := 0; i < 10; i++ {
+		i := i
+		h.Add(func() {
+			mux.Lock()
+			runs[i]++
+			first := runs[i] == 1
+			mux.Unlock()
+
+			if first && i%2 == 1 {
+				panic(i)
+			}
+		})
+	}
+	h.Wait()
+
+	panicked := h.PanickedJobs()
+	if len(panicked) != 5 {
+		t.Fatalf("expected %v but found %v", 5, len(panicked))
+	}
+	for _, f := range panicked {
+		f()
+	}
+	for i, n := range runs {
+		expected := 1 + i%2
+		if n != expected {
+			t.Fatalf("job %v: expected %v but found %v", i, expected, n)
+		}
+	}
+
+	if panicked := h.PanickedJobs(); len(panicked) != 0 {
+		t.Fatalf("expected %v but found %v", 0, len(panicked))
+	}
+	if n := h.Stats().Panicked; n != 5 {
+		t.Fatalf("expected %v but found %v", 5, n)
+	}
+}
+
+func TestPool_WithMaxLifetime(t *testing.T) {
+	lifetime := 200 * time.Millisecond
+	h := full(New(context.Background(), 2, WithMaxLifetime(lifetime)))
+
+	start := time.Now()
+	for h.Err() == nil {
+		h.Add(func() {
+			time.Sleep(10 * time.Millisecond)
+		})
+	}
+	h.Wait()
+
+	if elapsed := time.Since(start); elapsed < lifetime || elapsed > lifetime+100*time.Millisecond {
+		t.Fatalf("expected about %v but found %v", lifetime, elapsed)
+	}
+	if err := h.Err(); err != ErrMaxLifetime {
+		t.Fatalf("expected %v but found %v", ErrMaxLifetime, err)
+	}
+}
+
+func TestPool_WithMaxLifetimeStopped(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 10, WithMaxLifetime(time.Minute))),
+		"dynamic": full(New(context.Background(), 2, WithMaxLifetime(time.Minute))),
+	}
+	states := map[string]func() *poolState{
+		"fixed":   pools["fixed"].(*fixedPool).state.Load,
+		"dynamic": pools["dynamic"].(*dynamicPool).state.Load,
+	}
+
+	for name, h := range pools {
+		first := states[name]()
+		if err := h.Reset(); err != nil {
+			t.Fatalf("%v: expected %v but found %v", name, nil, err)
+		}
+		// Stop() returns false once the timer was stopped already
+		if first.lifetime.Stop() {
+			t.Fatalf("%v: expected %v but found %v", name, false, true)
+		}
+
+		h.ForceFinish()
+		if states[name]().lifetime.Stop() {
+			t.Fatalf("%v: expected %v but found %v", name, false, true)
+		}
+	}
+}
+
+func TestPool_IsDone(t *testing.T) {
+	pools := map[string]Pool{
+		"fixed":      NewFixedSize(context.Background(), 2, 10),
+		"dynamic":    New(context.Background(), 2),
+		"persistent": NewPersistent(context.Background(), 2),
+		"priority":   NewPriorityPool(context.Background(), 2),
+	}
+
+	for name, h := range pools {
+		if h.IsDone() {
+			t.Fatalf("%v: expected %v but found %v", name, false, true)
+		}
+		h.ForceFinish()
+		if !h.IsDone() {
+			t.Fatalf("%v: expected %v but found %v", name, true, false)
+		}
+		// wait on the workers, so they are not counted by later tests
+		h.(interface{ Close() error }).Close()
+	}
+}
+
+func TestPool_Err(t *testing.T) {
+	h := full(New(context.Background(), 2))
+	if err := h.Err(); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+	h.ForceFinish()
+	if err := h.Err(); err != context.Canceled {
+		t.Fatalf("expected %v but found %v", context.Canceled, err)
+	}
+
+	errParent := errors.New("parent")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	h = full(NewFixedSize(ctx, 2, 10))
+	cancel(errParent)
+	if err := h.Err(); err != errParent {
+		t.Fatalf("expected %v but found %v", errParent, err)
+	}
+}
+
+func TestPool_Config(t *testing.T) {
+	h := full(NewFixedSize(context.Background(), -1, 10,
+		WithSlotReclaim(time.Second),
+		WithPauseBuffer(3),
+		WithRampUp(time.Millisecond),
+		WithMaxLifetime(time.Minute),
+		WithPanicHandlerCtx(func(ctx context.Context, recovered any, stack []byte) {}),
+	))
+	defer h.ForceFinish()
+
+	expected := Config{
+		Kind:              Fixed,
+		ConcurrentThreads: runtime.NumCPU(),
+		TotalJobs:         10,
+		SlotReclaim:       time.Second,
+		PanicHandler:      true,
+		PauseBuffer:       3,
+		RampUp:            time.Millisecond,
+		MaxLifetime:       time.Minute,
+	}
+	if actual := h.Config(); actual != expected {
+		t.Fatalf("expected %+v but found %+v", expected, actual)
+	}
+
+	expected = Config{
+		Kind:              Dynamic,
+		ConcurrentThreads: 5,
+		TotalJobs:         -1,
+		GCBackoff:         true,
+	}
+	h = full(New(context.Background(), 5, WithGCBackoff()))
+	defer h.ForceFinish()
+	if actual := h.Config(); actual != expected {
+		t.Fatalf("expected %+v but found %+v", expected, actual)
+	}
+}
+
+func TestPool_NestedAdd(t *testing.T) {
+	depth := 5
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, depth, WithNestedJobs())),
+		"dynamic": full(New(context.Background(), 1, WithNestedJobs())),
+	}
+
+	for name, h := range pools {
+		var runs int32
+		var add func(n int)
+		add = func(n int) {
+			h.Add(func() {
+				atomic.AddInt32(&runs, 1)
+				if n > 1 {
+					add(n - 1)
+				}
+			})
+		}
+
+		done := make(chan bool)
+		go func() {
+			add(depth)
+			h.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected nested Add not to deadlock", name)
+		}
+
+		if runs != int32(depth) {
+			t.Fatalf("%v: expected %v but found %v", name, depth, runs)
+		}
+	}
+}
+
+func TestPool_ForceFinishFromJob(t *testing.T) {
+	concur := 2
+	total := 50
+	for _, noWait := range []bool{false, true} {
+		h := NewFixedSize(context.Background(), concur, total)
+
+		var runs int32
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < total; i++ {
+				f := func() {
+					if atomic.AddInt32(&runs, 1) == 3 {
+						h.ForceFinish()
+					}
+					time.Sleep(time.Millisecond)
+				}
+				if noWait {
+					h.AddNoWait(f)
+				} else {
+					h.Add(f)
+				}
+			}
+			h.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("noWait %v: expected Wait to return after ForceFinish", noWait)
+		}
+
+		if runs >= int32(total) {
+			t.Fatalf("noWait %v: expected fewer than %v runs but found %v", noWait, total, runs)
+		}
+		// every thread is given back once the jobs are done
+		if p := h.(*fixedPool); len(p.threads()) != concur {
+			t.Fatalf("noWait %v: expected %v but found %v", noWait, concur, len(p.threads()))
+		}
+	}
+
+	// Wait does not need the remaining jobs to be added
+	h := NewFixedSize(context.Background(), concur, total)
+	h.Add(func() { h.ForceFinish() })
+	done := make(chan bool)
+	go func() {
+		h.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Wait to return after ForceFinish")
+	}
+}
+
+func TestPool_Clone(t *testing.T) {
+	pools := []pool{
+		full(NewFixedSize(context.Background(), 3, 4, WithPauseBuffer(2), WithRampUp(time.Millisecond))),
+		full(New(context.Background(), 3, WithSlotReclaim(time.Second), WithRandSource(rand.New(rand.NewSource(1))))),
+	}
+
+	for _, h := range pools {
+		c := full(h.Clone(context.Background()))
+		if c.Config() != h.Config() {
+			t.Fatalf("expected %v but found %v", h.Config(), c.Config())
+		}
+
+		for i := 0; i < 4; i++ {
+			h.Add(func() {})
+		}
+		h.Wait()
+		h.ForceFinish()
+		if found := c.QueueLatencyPercentiles(); found != (LatencyPercentiles{}) {
+			t.Fatalf("expected %v but found %v", LatencyPercentiles{}, found)
+		}
+		if err := c.Err(); err != nil {
+			t.Fatalf("expected %v but found %v", nil, err)
+		}
+
+		var runs int32
+		for i := 0; i < 4; i++ {
+			c.Add(func() { atomic.AddInt32(&runs, 1) })
+		}
+		c.Wait()
+		if runs != 4 {
+			t.Fatalf("expected %v but found %v", 4, runs)
+		}
+	}
+}
+
+func TestPool_AddOrErr(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 1)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+
+	for name, h := range pools {
+		ran := make(chan bool)
+		if err := h.AddOrErr(func() { close(ran) }); err != nil {
+			t.Fatalf("%v: expected %v but found %v", name, nil, err)
+		}
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected the job to run", name)
+		}
+		h.Wait()
+
+		h.ForceFinish()
+		if err := h.AddOrErr(func() { t.Errorf("%v: expected the job not to run", name) }); err != ErrPoolClosed {
+			t.Fatalf("%v: expected %v but found %v", name, ErrPoolClosed, err)
+		}
+		h.Wait()
+	}
+}
+
+func TestPool_Reopen(t *testing.T) {
+	h := full(New(context.Background(), 2))
+	if err := full(NewFixedSize(context.Background(), 2, 1)).Reopen(); err != ErrReopenFixed {
+		t.Fatalf("expected %v but found %v", ErrReopenFixed, err)
+	}
+
+	block := make(chan bool)
+	h.Add(func() { <-block })
+	h.ForceFinish()
+	if err := h.Reopen(); err != ErrJobsInFlight {
+		t.Fatalf("expected %v but found %v", ErrJobsInFlight, err)
+	}
+	close(block)
+	h.Wait()
+
+	if err := h.Reopen(); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+	if err := h.Err(); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+	var runs int32
+	for i := 0; i < 10; i++ {
+		h.Add(func() { atomic.AddInt32(&runs, 1) })
+	}
+	h.Wait()
+	if runs != 10 {
+		t.Fatalf("expected %v but found %v", 10, runs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h = full(New(ctx, 2))
+	cancel()
+	if err := h.Reopen(); err != context.Canceled {
+		t.Fatalf("expected %v but found %v", context.Canceled, err)
+	}
+}
+
+func TestPool_WithMaxDepth(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 8, 15, WithMaxDepth(3))),
+		"dynamic": full(New(context.Background(), 8, WithMaxDepth(3))),
+	}
+
+	for name, h := range pools {
+		var runs, rejected int32
+		var spawn func()
+		spawn = func() {
+			atomic.AddInt32(&runs, 1)
+			for i := 0; i < 2; i++ {
+				if err := h.AddOrErr(spawn); err == ErrMaxDepth {
+					atomic.AddInt32(&rejected, 1)
+				} else if err != nil {
+					t.Errorf("%v: expected %v but found %v", name, nil, err)
+				}
+			}
+		}
+		h.Add(spawn)
+
+		done := make(chan bool)
+		go func() {
+			h.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected Wait to return", name)
+		}
+
+		// depths 1 to 3 run 1+2+4 jobs and the 8 jobs at depth 4 are rejected
+		if runs != 7 || rejected != 8 {
+			t.Fatalf("%v: expected %v and %v but found %v and %v", name, 7, 8, runs, rejected)
+		}
+		if n := h.Dropped()[DropMaxDepth]; n != 8 {
+			t.Fatalf("%v: expected %v but found %v", name, 8, n)
+		}
+		if c := h.Config().MaxDepth; c != 3 {
+			t.Fatalf("%v: expected %v but found %v", name, 3, c)
+		}
+	}
+}
+
+func TestPool_WithStallWarning(t *testing.T) {
+	type stall struct {
+		id      uint64
+		label   string
+		running time.Duration
+	}
+	stalls := make(chan stall, 10)
+	h := full(New(context.Background(), 4, WithStallWarning(30*time.Millisecond, func(jobID uint64, label string, running time.Duration) {
+		stalls <- stall{jobID, label, running}
+	})))
+
+	h.Add(func() {})
+	h.AddLabeled("slow", func() { time.Sleep(100 * time.Millisecond) })
+	h.AddLabeled("fast", func() {})
+	h.Wait()
+
+	var found []stall
+	for len(stalls) > 0 {
+		found = append(found, <-stalls)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected %v but found %v", 1, found)
+	}
+	if s := found[0]; s.id != 2 || s.label != "slow" || s.running < 30*time.Millisecond {
+		t.Fatalf("expected the slow job but found %+v", s)
+	}
+}
+
+func TestPool_Then(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, 9)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+
+	for name, h := range pools {
+		var phase1, phase2 int32
+		for i := 0; i < 5; i++ {
+			h.Add(func() {
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&phase1, 1)
+			})
+		}
+		h.Then(func() {
+			if n := atomic.LoadInt32(&phase1); n != 5 {
+				t.Errorf("%v: expected %v but found %v", name, 5, n)
+			}
+			for i := 0; i < 3; i++ {
+				h.Add(func() {
+					if n := atomic.LoadInt32(&phase1); n != 5 {
+						t.Errorf("%v: expected %v but found %v", name, 5, n)
+					}
+					atomic.AddInt32(&phase2, 1)
+				})
+			}
+		})
+		h.Wait()
+
+		if phase2 != 3 {
+			t.Fatalf("%v: expected %v but found %v", name, 3, phase2)
+		}
+	}
+}
+
+func TestPool_Handoff(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 3, WithNestedJobs())),
+		"dynamic": full(New(context.Background(), 1, WithNestedJobs())),
+	}
+
+	for name, h := range pools {
+		if h.Handoff(func() {}) {
+			t.Fatalf("%v: expected Handoff to fail outside a job", name)
+		}
+
+		var mux sync.Mutex
+		var order []string
+		record := func(s string) {
+			mux.Lock()
+			order = append(order, s)
+			mux.Unlock()
+		}
+
+		queued := make(chan bool)
+		h.Add(func() {
+			record("producer")
+			<-queued
+			if !h.Handoff(func() { record("consumer") }) {
+				t.Errorf("%v: expected Handoff to succeed", name)
+			}
+		})
+		// waits for the producer's thread while the producer runs
+		h.AddNoWait(func() { record("other") })
+		time.Sleep(20 * time.Millisecond)
+		close(queued)
+		h.Wait()
+
+		expected := []string{"producer", "consumer", "other"}
+		if len(order) != len(expected) {
+			t.Fatalf("%v: expected %v but found %v", name, expected, order)
+		}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Fatalf("%v: expected %v but found %v", name, expected, order)
+			}
+		}
+	}
+
+	// without WithNestedJobs the jobs are not tracked
+	h := full(New(context.Background(), 1))
+	h.Add(func() {
+		if h.Handoff(func() {}) {
+			t.Errorf("expected Handoff to fail without WithNestedJobs")
+		}
+	})
+	h.Wait()
+}
+
+func TestPool_GoroutinesSpawned(t *testing.T) {
+	total := 25
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, total)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+
+	for name, h := range pools {
+		for i := 0; i < total; i++ {
+			if i%2 == 0 {
+				h.Add(func() {})
+			} else {
+				h.AddNoWait(func() {})
+			}
+		}
+		h.Wait()
+
+		if n := h.GoroutinesSpawned(); n != int64(total) {
+			t.Fatalf("%v: expected %v but found %v", name, total, n)
+		}
+	}
+}
+
+func TestPool_ForceFinishN(t *testing.T) {
+	pools := map[string]struct {
+		h        pool
+		expected int
+	}{
+		// 6 jobs left of totalJobs plus the 3 waiting
+		"fixed":   {full(NewFixedSize(context.Background(), 1, 10)), 9},
+		"dynamic": {full(New(context.Background(), 1)), 3},
+	}
+
+	for name, test := range pools {
+		h := test.h
+		block := make(chan bool)
+		h.Add(func() { <-block })
+		var runs int32
+		for i := 0; i < 3; i++ {
+			h.AddNoWait(func() { atomic.AddInt32(&runs, 1) })
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		if n := h.ForceFinishN(); n != test.expected {
+			t.Fatalf("%v: expected %v but found %v", name, test.expected, n)
+		}
+		if n := h.ForceFinishN(); n != 0 {
+			t.Fatalf("%v: expected %v but found %v", name, 0, n)
+		}
+		close(block)
+		h.Wait()
+		if runs != 0 {
+			t.Fatalf("%v: expected %v but found %v", name, 0, runs)
+		}
+	}
+}
+
+func TestPool_AddIfFresh(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 3)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+
+	for name, h := range pools {
+		block := make(chan bool)
+		h.Add(func() { <-block })
+
+		var needed int32 = 1
+		var runs int32
+		fresh := func() bool { return atomic.LoadInt32(&needed) == 1 }
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			atomic.StoreInt32(&needed, 0)
+			close(block)
+		}()
+		// waits for the blocked thread, by which time it is stale
+		h.AddIfFresh(fresh, func() { atomic.AddInt32(&runs, 1) })
+		h.Barrier()()
+		if runs != 0 {
+			t.Fatalf("%v: expected %v but found %v", name, 0, runs)
+		}
+		if n := h.Dropped()[DropStale]; n != 1 {
+			t.Fatalf("%v: expected %v but found %v", name, 1, n)
+		}
+
+		atomic.StoreInt32(&needed, 1)
+		h.AddIfFresh(fresh, func() { atomic.AddInt32(&runs, 1) })
+		h.Wait()
+		if runs != 1 {
+			t.Fatalf("%v: expected %v but found %v", name, 1, runs)
+		}
+	}
+}
+
+func TestPool_WaitErr(t *testing.T) {
+	errGroup := errors.New("group failed")
+	for _, fixed := range []bool{true, false} {
+		// a parent cancelled with a cause, as an errgroup's context is
+		ctx, cancel := context.WithCancelCause(context.Background())
+		var h pool
+		if fixed {
+			h = full(NewFixedSize(ctx, 2, 100))
+		} else {
+			h = full(New(ctx, 2))
+		}
+
+		for i := 0; i < 5; i++ {
+			h.Add(func() { time.Sleep(10 * time.Millisecond) })
+		}
+		if fixed {
+			// the unfinished pool is stopped on the parent cancelling
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				cancel(errGroup)
+			}()
+		} else {
+			cancel(errGroup)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- h.WaitErr()
+		}()
+		select {
+		case err := <-done:
+			if err != errGroup {
+				t.Fatalf("fixed %v: expected %v but found %v", fixed, errGroup, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("fixed %v: expected WaitErr to return", fixed)
+		}
+	}
+
+	h := full(New(context.Background(), 2))
+	h.Add(func() {})
+	if err := h.WaitErr(); err != nil {
+		t.Fatalf("expected %v but found %v", nil, err)
+	}
+}
+
+func TestPool_PanicWithoutHandler(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 4)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+
+	for name, h := range pools {
+		var runs int32
+		h.Add(func() { panic("boom") })
+		h.AddNoWait(func() { panic("boom") })
+		for i := 0; i < 2; i++ {
+			h.Add(func() { atomic.AddInt32(&runs, 1) })
+		}
+
+		done := make(chan bool)
+		go func() {
+			h.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected Wait to return after jobs panicked", name)
+		}
+
+		if runs != 2 {
+			t.Fatalf("%v: expected %v but found %v", name, 2, runs)
+		}
+		if n := len(h.PanickedJobs()); n != 2 {
+			t.Fatalf("%v: expected %v but found %v", name, 2, n)
+		}
+	}
+	if !strings.Contains(logged.String(), "boom") {
+		t.Fatalf("expected the panic to be logged but found %q", logged.String())
+	}
+}
+
+func TestPool_WithPanicHandler(t *testing.T) {
+	var panics int32
+	var stacks int32
+	h := full(NewFixedSize(context.Background(), 2, 10, WithPanicHandler(func(recovered any, stack []byte) {
+		if recovered == "boom" {
+			atomic.AddInt32(&panics, 1)
+		}
+		if bytes.Contains(stack, []byte("TestPool_WithPanicHandler")) {
+			atomic.AddInt32(&stacks, 1)
+		}
+	})))
+
+	var runs int32
+	for i := 0; i < 10; i++ {
+		i := i
+		h.Add(func() {
+			if i%3 == 0 {
+				panic("boom")
+			}
+			atomic.AddInt32(&runs, 1)
+		})
+	}
+	h.Wait()
+
+	if panics != 4 || stacks != 4 || runs != 6 {
+		t.Fatalf("expected %v, %v and %v but found %v, %v and %v", 4, 4, 6, panics, stacks, runs)
+	}
+	if !h.Config().PanicHandler {
+		t.Fatalf("expected the panic handler to be reported by Config")
+	}
+}
+
+func TestPool_TryAdd(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 3)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+
+	for name, h := range pools {
+		block := make(chan bool)
+		for i := 0; i < 2; i++ {
+			if !h.TryAdd(func() { <-block }) {
+				t.Fatalf("%v: expected TryAdd to succeed with a free thread", name)
+			}
+		}
+		if h.TryAdd(func() { t.Errorf("%v: expected the job not to run", name) }) {
+			t.Fatalf("%v: expected TryAdd to fail with every thread busy", name)
+		}
+		close(block)
+		h.Barrier()()
+
+		// the rejected job did not use up one of totalJobs
+		ran := make(chan bool)
+		if !h.TryAdd(func() { close(ran) }) {
+			t.Fatalf("%v: expected TryAdd to succeed once threads are free", name)
+		}
+		<-ran
+		h.Wait()
+
+		h.ForceFinish()
+		if h.TryAdd(func() {}) {
+			t.Fatalf("%v: expected TryAdd to fail once finished", name)
+		}
+	}
+}
+
+func TestPool_Stats(t *testing.T) {
+	h := full(NewFixedSize(context.Background(), 3, 10))
+	if s := h.Stats(); s != (Stats{Available: 3, Pending: 10}) {
+		t.Fatalf("expected %+v but found %+v", Stats{Available: 3, Pending: 10}, s)
+	}
+
+	block := make(chan bool)
+	for i := 0; i < 2; i++ {
+		h.Add(func() { <-block })
+	}
+	ran := make(chan bool)
+	h.Add(func() { close(ran) })
+	<-ran
+	for i := 0; i < 2; i++ {
+		h.AddNoWait(func() {})
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// the last thread is taken by one of the AddNoWait jobs while the other waits, or it
+	// has already completed
+	s := h.Stats()
+	if s.Pending != 5 || s.Running+s.Available != 3 || s.Completed < 1 {
+		t.Fatalf("expected 5 pending with 3 threads but found %+v", s)
+	}
+	close(block)
+	for i := 0; i < 5; i++ {
+		h.Add(func() {})
+	}
+	h.Wait()
+
+	s = h.Stats()
+	if s.WaitCount != 10 || s.TotalWaitTime <= 0 {
+		t.Fatalf("expected %v waits but found %+v", 10, s)
+	}
+	s.WaitCount, s.TotalWaitTime = 0, 0
+	expected := Stats{Available: 3, Completed: 10}
+	if s != expected {
+		t.Fatalf("expected %+v but found %+v", expected, s)
+	}
+
+	// the second job waits for the first to free the only thread
+	d := full(New(context.Background(), 1))
+	d.Add(func() { time.Sleep(20 * time.Millisecond) })
+	d.Add(func() {})
+	d.Wait()
+	s = d.Stats()
+	if s.TotalWaitTime < 20*time.Millisecond {
+		t.Fatalf("expected at least %v but found %v", 20*time.Millisecond, s.TotalWaitTime)
+	}
+	s.TotalWaitTime = 0
+	if s != (Stats{Available: 1, Completed: 2, WaitCount: 2}) {
+		t.Fatalf("expected %+v but found %+v", Stats{Available: 1, Completed: 2, WaitCount: 2}, s)
+	}
+}
+
+func TestPool_AddCtx(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 4)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+
+	for name, h := range pools {
+		// a job whose context expires while it waits for a thread is skipped
+		block := make(chan bool)
+		h.Add(func() { <-block })
+		expired, expire := context.WithCancel(context.Background())
+		added := make(chan bool)
+		go func() {
+			h.AddCtx(expired, func(context.Context) { t.Errorf("%v: expected the job not to run", name) })
+			close(added)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		expire()
+		close(block)
+		<-added
+		h.Barrier()()
+		if n := h.Dropped()[DropExpired]; n != 1 {
+			t.Fatalf("%v: expected %v but found %v", name, 1, n)
+		}
+
+		// cancelling the job's context only stops that job
+		errJob := errors.New("job cancelled")
+		jobCtx, cancelJob := context.WithCancelCause(context.Background())
+		var cause error
+		h.AddCtx(jobCtx, func(ctx context.Context) {
+			cancelJob(errJob)
+			<-ctx.Done()
+			cause = context.Cause(ctx)
+		})
+		h.Barrier()()
+		if cause != errJob || h.IsDone() {
+			t.Fatalf("%v: expected %v but found %v", name, errJob, cause)
+		}
+
+		// the pool finishing cancels the job's context too
+		started := make(chan bool)
+		h.AddCtx(context.Background(), func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			cause = context.Cause(ctx)
+		})
+		<-started
+		h.ForceFinish()
+		h.Wait()
+		if cause != context.Canceled {
+			t.Fatalf("%v: expected %v but found %v", name, context.Canceled, cause)
+		}
+	}
+}
+
+func TestPool_WaitTimeout(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 1)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+
+	for name, h := range pools {
+		block := make(chan bool)
+		h.Add(func() { <-block })
+		if h.WaitTimeout(20 * time.Millisecond) {
+			t.Fatalf("%v: expected %v but found %v", name, false, true)
+		}
+		close(block)
+		if !h.WaitTimeout(time.Second) {
+			t.Fatalf("%v: expected %v but found %v", name, true, false)
+		}
+	}
+}
+
+func TestPool_Resize(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, 40)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+
+	for name, h := range pools {
+		var running, peak int32
+		job := func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}
+
+		if err := h.Resize(0); err != ErrResize {
+			t.Fatalf("%v: expected %v but found %v", name, ErrResize, err)
+		}
+
+		// shrink while every thread is busy
+		for i := 0; i < 4; i++ {
+			h.Add(job)
+		}
+		if err := h.Resize(2); err != nil {
+			t.Fatalf("%v: expected %v but found %v", name, nil, err)
+		}
+		h.Barrier()()
+		atomic.StoreInt32(&peak, 0)
+		for i := 0; i < 16; i++ {
+			h.Add(job)
+		}
+		h.Barrier()()
+		if p := atomic.LoadInt32(&peak); p != 2 {
+			t.Fatalf("%v: expected %v but found %v", name, 2, p)
+		}
+		if s := h.Stats(); s.Running != 0 || s.Available != 2 {
+			t.Fatalf("%v: expected 2 available but found %+v", name, s)
+		}
+
+		// grow back
+		if err := h.Resize(4); err != nil {
+			t.Fatalf("%v: expected %v but found %v", name, nil, err)
+		}
+		atomic.StoreInt32(&peak, 0)
+		for i := 0; i < 20; i++ {
+			h.Add(job)
+		}
+		h.Wait()
+		if p := atomic.LoadInt32(&peak); p != 4 {
+			t.Fatalf("%v: expected %v but found %v", name, 4, p)
+		}
+	}
+}
+
+func TestPool_ResizeGrow(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 6)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+
+	for name, h := range pools {
+		var running int32
+		block := make(chan bool)
+		job := func() {
+			atomic.AddInt32(&running, 1)
+			<-block
+		}
+
+		// two jobs hold the threads and four wait on the pool as it grows
+		for i := 0; i < 6; i++ {
+			h.AddNoWait(job)
+		}
+		if _, err := h.ReserveSlots(3); err != ErrReserveCapacity {
+			t.Fatalf("%v: expected %v but found %v", name, ErrReserveCapacity, err)
+		}
+		if err := h.Resize(6); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&running) != 6 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := atomic.LoadInt32(&running); n != 6 {
+			t.Fatalf("%v: expected %v running but found %v", name, 6, n)
+		}
+		close(block)
+		h.Wait()
+
+		if c := h.Concurrency(); c != 6 {
+			t.Fatalf("%v: expected %v but found %v", name, 6, c)
+		}
+		if s := h.Stats(); s.Available != 6 {
+			t.Fatalf("%v: expected 6 available but found %+v", name, s)
+		}
+		r, err := h.ReserveSlots(6)
+		if err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		r.Release()
+		h.ForceFinish()
+	}
+}
+
+func TestPool_ConcurrentCancel(t *testing.T) {
+	// many goroutines see the cancellation at once, each must account for its own job only
+	for round := 0; round < 20; round++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		total := 200
+		h := full(NewFixedSize(ctx, 2, total))
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				job := func() { time.Sleep(100 * time.Microsecond) }
+				for i := g; i < total; i += 8 {
+					switch i % 4 {
+					case 0:
+						h.Add(job)
+					case 1:
+						h.AddNoWait(job)
+					case 2:
+						h.AddBatch([]func(){job})
+					default:
+						h.AddAfter(time.Duration(i%3)*time.Millisecond, job)
+					}
+				}
+			}(g)
+		}
+		time.Sleep(time.Duration(round%5) * time.Millisecond)
+		cancel()
+		wg.Wait()
+
+		if !h.WaitTimeout(time.Second) {
+			t.Fatalf("round %v: expected Wait to return after cancelling", round)
+		}
+	}
+}
+
+func TestPool_Drain(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 10)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+
+	for name, h := range pools {
+		var runs int32
+		block := make(chan bool)
+		h.Add(func() {
+			<-block
+			atomic.AddInt32(&runs, 1)
+		})
+		// accepted but waiting on the busy thread
+		for i := 0; i < 3; i++ {
+			h.AddNoWait(func() { atomic.AddInt32(&runs, 1) })
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		h.Drain()
+		if !h.Draining() || h.IsDone() {
+			t.Fatalf("%v: expected draining but not done", name)
+		}
+		h.AddNoWait(func() { t.Errorf("%v: expected no jobs after Drain", name) })
+		if err := h.AddOrErr(func() {}); err != ErrPoolClosed {
+			t.Fatalf("%v: expected %v but found %v", name, ErrPoolClosed, err)
+		}
+		close(block)
+
+		if !h.WaitTimeout(time.Second) {
+			t.Fatalf("%v: expected Wait to return after draining", name)
+		}
+		if runs != 4 {
+			t.Fatalf("%v: expected %v but found %v", name, 4, runs)
+		}
+	}
+}
+
+func TestPool_NegativeTotal(t *testing.T) {
+	for _, total := range []int{0, -5} {
+		h := full(NewFixedSize(context.Background(), 4, total))
+		if h.Total() != 0 {
+			t.Fatalf("%v: expected %v but found %v", total, 0, h.Total())
+		}
+		if err := h.AddOrErr(func() { t.Errorf("%v: expected no jobs to run", total) }); err != ErrPoolClosed {
+			t.Fatalf("%v: expected %v but found %v", total, ErrPoolClosed, err)
+		}
+		if !h.WaitTimeout(time.Second) {
+			t.Fatalf("%v: expected Wait to return at once", total)
+		}
+	}
+}
+
+func TestPool_AddIndexed(t *testing.T) {
+	total := 20
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, total)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+	for name, h := range pools {
+		seen := make([]int32, total)
+		// no copy of i is made, AddIndexed takes it by value
+		for i := 0; i < total; i++ {
+			h.AddIndexed(i, func(i int) {
+				atomic.AddInt32(&seen[i], 1)
+			})
+		}
+		h.Wait()
+
+		for i, n := range seen {
+			if n != 1 {
+				t.Fatalf("%v: index %v: expected %v but found %v", name, i, 1, n)
+			}
+		}
+	}
+}
+
+func TestPool_CommitRemaining(t *testing.T) {
+	h := full(NewFixedSize(context.Background(), 2, 10))
+
+	var runs int32
+	block := make(chan bool)
+	for i := 0; i < 4; i++ {
+		h.AddNoWait(func() {
+			<-block
+			atomic.AddInt32(&runs, 1)
+		})
+	}
+
+	if n := h.CommitRemaining(); n != 6 {
+		t.Fatalf("expected %v but found %v", 6, n)
+	}
+	if n := h.CommitRemaining(); n != 0 {
+		t.Fatalf("expected %v but found %v", 0, n)
+	}
+	if h.WaitTimeout(20 * time.Millisecond) {
+		t.Fatalf("expected Wait to wait on the jobs already added")
+	}
+	close(block)
+
+	if !h.WaitTimeout(time.Second) {
+		t.Fatalf("expected Wait to return once the jobs added complete")
+	}
+	if runs != 4 {
+		t.Fatalf("expected %v but found %v", 4, runs)
+	}
+	if h.IsDone() {
+		t.Fatalf("expected the jobs to complete without finishing the pool")
+	}
+
+	d := full(New(context.Background(), 1))
+	if n := d.CommitRemaining(); n != 0 || !d.Draining() {
+		t.Fatalf("expected %v and draining but found %v and %v", 0, n, d.Draining())
+	}
+}
+
+func TestPool_AddBatch(t *testing.T) {
+	total := 10
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, total)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		var runs int32
+		batch := make([]func(), 6)
+		for i := range batch {
+			batch[i] = func() { atomic.AddInt32(&runs, 1) }
+		}
+		if err := h.AddBatch(batch); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+
+		// only 4 of totalJobs are left for the fixed pool
+		err := h.AddBatch(batch)
+		expected := 12
+		if name == "fixed" {
+			if err != ErrBatchSize {
+				t.Fatalf("%v: expected %v but found %v", name, ErrBatchSize, err)
+			}
+			if err := h.AddBatch(batch[:4]); err != nil {
+				t.Fatalf("%v: expected no error but found %v", name, err)
+			}
+			expected = total
+		} else if err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+
+		if !h.WaitTimeout(time.Second) {
+			t.Fatalf("%v: expected Wait to return", name)
+		}
+		if runs != int32(expected) {
+			t.Fatalf("%v: expected %v but found %v", name, expected, runs)
+		}
+	}
+}
+
+func TestPool_AddErr(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, 21)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+	for name, h := range pools {
+		fail := errors.New("fail")
+		for i := 0; i < 20; i++ {
+			i := i
+			h.AddErr(func() error {
+				if i%4 == 0 {
+					return fmt.Errorf("job %v: %w", i, fail)
+				}
+				return nil
+			})
+		}
+		h.AddRetryIf(3, time.Millisecond, func(error) bool { return true }, func() error { return fail })
+		h.Wait()
+
+		errs := h.Errors()
+		if len(errs) != 6 {
+			t.Fatalf("%v: expected %v but found %v", name, 6, len(errs))
+		}
+		for _, err := range errs {
+			if !errors.Is(err, fail) {
+				t.Fatalf("%v: expected %v but found %v", name, fail, err)
+			}
+		}
+	}
+}
+
+func TestPool_WithCancelOnError(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 100, WithCancelOnError())),
+		"dynamic": full(New(context.Background(), 2, WithCancelOnError())),
+	}
+	for name, h := range pools {
+		first := errors.New("first")
+		var runs int32
+		h.AddErr(func() error { return first })
+		for i := 0; i < 99; i++ {
+			h.AddErr(func() error {
+				atomic.AddInt32(&runs, 1)
+				time.Sleep(time.Millisecond)
+				return errors.New("later")
+			})
+		}
+
+		if err := h.WaitErr(); err != first {
+			t.Fatalf("%v: expected %v but found %v", name, first, err)
+		}
+		if !h.IsDone() {
+			t.Fatalf("%v: expected the pool to be finished", name)
+		}
+		if runs > 2 {
+			t.Fatalf("%v: expected at most %v more jobs but found %v", name, 2, runs)
+		}
+	}
+}
+
+func TestPool_WithErrorThreshold(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 10, WithErrorThreshold(3))),
+		"dynamic": full(New(context.Background(), 1, WithErrorThreshold(3))),
+	}
+	for name, h := range pools {
+		var mux sync.Mutex
+		var results []int
+		var errs []error
+		for i := 0; i < 10; i++ {
+			i := i
+			err := fmt.Errorf("job %v", i)
+			if i%2 == 1 {
+				errs = append(errs, err)
+			}
+			h.AddErr(func() error {
+				if i%2 == 1 {
+					return err
+				}
+				mux.Lock()
+				defer mux.Unlock()
+				results = append(results, i)
+				return nil
+			})
+		}
+
+		err := h.WaitErr()
+		for i, e := range errs {
+			if errors.Is(err, e) != (i < 3) {
+				t.Fatalf("%v: expected only the first %v errors but found %v", name, 3, err)
+			}
+		}
+		if !h.IsDone() {
+			t.Fatalf("%v: expected the pool to be finished", name)
+		}
+		// the jobs before the third error kept their results
+		expected := []int{0, 2, 4}
+		if fmt.Sprint(results) != fmt.Sprint(expected) {
+			t.Fatalf("%v: expected %v but found %v", name, expected, results)
+		}
+	}
+}
+
+func TestPool_Reset(t *testing.T) {
+	total := 10
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, total)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		block := make(chan bool)
+		h.AddNoWait(func() { <-block })
+		if err := h.Reset(); err != ErrJobsInFlight {
+			t.Fatalf("%v: expected %v but found %v", name, ErrJobsInFlight, err)
+		}
+		close(block)
+		h.ForceFinish()
+		h.Wait()
+
+		for round := 0; round < 3; round++ {
+			if err := h.Reset(); err != nil {
+				t.Fatalf("%v: expected no error but found %v", name, err)
+			}
+			if h.IsDone() {
+				t.Fatalf("%v: expected the pool to be running after Reset", name)
+			}
+
+			var runs int32
+			for i := 0; i < total; i++ {
+				h.Add(func() { atomic.AddInt32(&runs, 1) })
+			}
+			if !h.WaitTimeout(time.Second) {
+				t.Fatalf("%v: expected Wait to return", name)
+			}
+			if runs != int32(total) {
+				t.Fatalf("%v: expected %v but found %v", name, total, runs)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := full(NewFixedSize(ctx, 2, total))
+	cancel()
+	if err := h.Reset(); err != context.Canceled {
+		t.Fatalf("expected %v but found %v", context.Canceled, err)
+	}
+}
+
+func TestPool_ResetWhileInspected(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 4)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		stop := make(chan bool)
+		polled := make(chan bool)
+		go func() {
+			defer close(polled)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_ = h.Stats()
+				_ = h.IsDone()
+				_ = h.Err()
+				_ = h.String()
+			}
+		}()
+
+		// the race is only seen when a read lands just as open() runs
+		for i := 0; i < 2000; i++ {
+			h.ForceFinish()
+			h.Wait()
+			if err := h.Reopen(); err != nil && err != ErrReopenFixed {
+				t.Fatalf("%v: expected no error but found %v", name, err)
+			}
+			if err := h.Reset(); err != nil {
+				t.Fatalf("%v: expected no error but found %v", name, err)
+			}
+		}
+		close(stop)
+		<-polled
+		h.ForceFinish()
+	}
+}
+
+func TestPool_ResetHeld(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 10)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		// a thread taken by Acquire would overfill the new threads once released
+		if err := h.Acquire(context.Background()); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		if err := h.Reset(); err != ErrJobsInFlight {
+			t.Fatalf("%v: expected %v but found %v", name, ErrJobsInFlight, err)
+		}
+		h.Release()
+
+		r, err := h.ReserveSlots(2)
+		if err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		if !r.Add(func() {}) {
+			t.Fatalf("%v: expected the reserved job to be added", name)
+		}
+		for range h.DrainProgress() {
+		}
+		if err := h.Reset(); err != ErrJobsInFlight {
+			t.Fatalf("%v: expected %v but found %v", name, ErrJobsInFlight, err)
+		}
+		r.Release()
+
+		if err := h.Reset(); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		released := make(chan bool)
+		go func() {
+			defer close(released)
+			for i := 0; i < 3; i++ {
+				if err := h.Acquire(context.Background()); err != nil {
+					t.Errorf("%v: expected no error but found %v", name, err)
+					return
+				}
+				h.Release()
+			}
+		}()
+		select {
+		case <-released:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected Release to return after Reset", name)
+		}
+		h.ForceFinish()
+	}
+}
+
+func TestPool_ConcurrencyTotal(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, 10)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+	expected := map[string]int{"fixed": 10, "dynamic": -1}
+	for name, h := range pools {
+		if c := h.Concurrency(); c != 4 {
+			t.Fatalf("%v: expected %v but found %v", name, 4, c)
+		}
+		if total := h.Total(); total != expected[name] {
+			t.Fatalf("%v: expected %v but found %v", name, expected[name], total)
+		}
+		if err := h.Resize(2); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		if c := h.Concurrency(); c != 2 {
+			t.Fatalf("%v: expected %v but found %v", name, 2, c)
+		}
+		h.ForceFinish()
+	}
+
+	q := NewPersistent(context.Background(), 4)
+	defer q.(*QueuePool).Close()
+	pq := NewPriorityPool(context.Background(), 4)
+	defer pq.Close()
+	for name, h := range map[string]Pool{"persistent": q, "priority": pq} {
+		if c := h.Concurrency(); c != 4 {
+			t.Fatalf("%v: expected %v but found %v", name, 4, c)
+		}
+		if total := h.Total(); total != -1 {
+			t.Fatalf("%v: expected %v but found %v", name, -1, total)
+		}
+	}
+
+	if c := full(New(context.Background(), 0)).Concurrency(); c != runtime.NumCPU() {
+		t.Fatalf("expected %v but found %v", runtime.NumCPU(), c)
+	}
+}
+
+func TestPool_WithProgress(t *testing.T) {
+	total := 50
+	for _, name := range []string{"fixed", "dynamic"} {
+		var calls, last, totals int32
+		progress := func(completed, total int) {
+			atomic.AddInt32(&calls, 1)
+			for {
+				l := atomic.LoadInt32(&last)
+				if int32(completed) <= l || atomic.CompareAndSwapInt32(&last, l, int32(completed)) {
+					break
+				}
+			}
+			atomic.StoreInt32(&totals, int32(total))
+		}
+
+		var h Pool
+		expected := total
+		if name == "fixed" {
+			h = NewFixedSize(context.Background(), 4, total, WithProgress(progress))
+		} else {
+			h = New(context.Background(), 4, WithProgress(progress))
+			expected = -1
+		}
+		for i := 0; i < total; i++ {
+			h.Add(func() {})
+		}
+		h.Wait()
+
+		if calls != int32(total) || last != int32(total) {
+			t.Fatalf("%v: expected %v but found %v calls up to %v", name, total, calls, last)
+		}
+		if totals != int32(expected) {
+			t.Fatalf("%v: expected %v but found %v", name, expected, totals)
+		}
+	}
+}
+
+func TestPool_WaitN(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 4, 100)),
+		"dynamic": full(New(context.Background(), 4)),
+	}
+	for name, h := range pools {
+		h := h
+		var runs int32
+		added := make(chan bool)
+		go func() {
+			defer close(added)
+			for i := 0; i < 100; i++ {
+				h.Add(func() {
+					time.Sleep(time.Millisecond)
+					atomic.AddInt32(&runs, 1)
+				})
+			}
+		}()
+
+		h.WaitN(5)
+		if r := atomic.LoadInt32(&runs); r < 5 {
+			t.Fatalf("%v: expected at least %v but found %v", name, 5, r)
+		}
+		h.ForceFinish()
+		<-added
+		h.Wait()
+		if r := atomic.LoadInt32(&runs); r == 100 {
+			t.Fatalf("%v: expected the rest of the jobs to be dropped", name)
+		}
+
+		// a finished pool does not block
+		done := make(chan bool)
+		go func() {
+			h.WaitN(1000)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected WaitN to return once finished", name)
+		}
+	}
+}
+
+func TestPool_WaitUntil(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 100)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		h := h
+		added := make(chan bool)
+		go func() {
+			defer close(added)
+			for i := 0; i < 100; i++ {
+				h.Add(func() {
+					time.Sleep(time.Millisecond)
+				})
+			}
+		}()
+
+		if !h.WaitUntil(func(s Stats) bool { return s.Completed >= 10 }) {
+			t.Fatalf("%v: expected %v but found %v", name, true, false)
+		}
+		// it returns as the 10th job completes, not once they all have
+		if c := h.Stats().Completed; c < 10 || c >= 100 {
+			t.Fatalf("%v: expected about %v but found %v", name, 10, c)
+		}
+		if !h.WaitUntil(func(s Stats) bool { return s.Running == 2 }) {
+			t.Fatalf("%v: expected %v but found %v", name, true, false)
+		}
+
+		h.ForceFinish()
+		<-added
+		h.Wait()
+
+		// a finished pool does not block
+		done := make(chan bool)
+		go func() {
+			if h.WaitUntil(func(s Stats) bool { return false }) {
+				t.Errorf("%v: expected %v but found %v", name, false, true)
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: expected WaitUntil to return once finished", name)
+		}
+	}
+}
+
+func TestPool_WaitHeartbeat(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 10)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		for i := 0; i < 10; i++ {
+			h.AddNoWait(func() {
+				time.Sleep(20 * time.Millisecond)
+			})
+		}
+
+		var beats int32
+		h.WaitHeartbeat(10*time.Millisecond, func(s Stats) {
+			atomic.AddInt32(&beats, 1)
+		})
+		if c := h.Stats().Completed; c != 10 {
+			t.Fatalf("%v: expected %v but found %v", name, 10, c)
+		}
+		n := atomic.LoadInt32(&beats)
+		if n < 3 {
+			t.Fatalf("%v: expected at least %v but found %v", name, 3, n)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if after := atomic.LoadInt32(&beats); after != n {
+			t.Fatalf("%v: expected %v but found %v", name, n, after)
+		}
+	}
+}
+
+func TestPool_WithRateLimit(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 8, 20, WithRateLimit(100, 1))),
+		"dynamic": full(New(context.Background(), 8, WithRateLimit(100, 1))),
+	}
+	for name, h := range pools {
+		var runs int32
+		start := time.Now()
+		for i := 0; i < 20; i++ {
+			h.Add(func() { atomic.AddInt32(&runs, 1) })
+		}
+		h.Wait()
+
+		// 20 starts at 100 per second with a burst of 1 take at least 190ms
+		if d := time.Since(start); d < 190*time.Millisecond {
+			t.Fatalf("%v: expected at least %v but found %v", name, 190*time.Millisecond, d)
+		}
+		if runs != 20 {
+			t.Fatalf("%v: expected %v but found %v", name, 20, runs)
+		}
+	}
+
+	// jobs waiting on the limiter when the pool finishes are dropped
+	h := full(New(context.Background(), 4, WithRateLimit(1, 1)))
+	var runs int32
+	for i := 0; i < 4; i++ {
+		h.Add(func() { atomic.AddInt32(&runs, 1) })
+	}
+	time.Sleep(10 * time.Millisecond)
+	h.ForceFinish()
+	if !h.WaitTimeout(time.Second) {
+		t.Fatalf("expected Wait to return after ForceFinish")
+	}
+	if runs != 1 {
+		t.Fatalf("expected %v but found %v", 1, runs)
+	}
+	if dropped := h.Dropped()[DropRateLimited]; dropped != 3 {
+		t.Fatalf("expected %v but found %v", 3, dropped)
+	}
+	if s := h.Stats(); s.Completed != 1 {
+		t.Fatalf("expected %v but found %v", 1, s.Completed)
+	}
+}
+
+func TestPool_AddWithValues(t *testing.T) {
+	type traceKey struct{}
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 2)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+	for name, h := range pools {
+		// the values are kept even once the caller's context is cancelled
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceKey{}, "trace-1"))
+		cancel()
+		var trace any
+		var err error
+		h.AddWithValues(ctx, func(ctx context.Context) {
+			trace = ctx.Value(traceKey{})
+			err = ctx.Err()
+		})
+		h.Barrier()()
+		if trace != "trace-1" || err != nil {
+			t.Fatalf("%v: expected %v but found %v and %v", name, "trace-1", trace, err)
+		}
+
+		// the pool finishing cancels the job's context
+		started := make(chan bool)
+		done := make(chan error)
+		h.AddWithValues(context.Background(), func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			done <- ctx.Err()
+		})
+		<-started
+		h.ForceFinish()
+		if err := <-done; err != context.Canceled {
+			t.Fatalf("%v: expected %v but found %v", name, context.Canceled, err)
+		}
+	}
+}
+
+func TestPool_ForceFinished(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 10)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		var wins int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if h.ForceFinished() {
+					atomic.AddInt32(&wins, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		if wins != 1 {
+			t.Fatalf("%v: expected %v but found %v", name, 1, wins)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := full(New(ctx, 2))
+	cancel()
+	if h.ForceFinished() {
+		t.Fatalf("expected false once the parent context is done")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to read while the log is written to from other goroutines.
+type lockedBuffer struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.String()
+}
+
+func TestPool_WithStallDetector(t *testing.T) {
+	var logged lockedBuffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 10, WithStallDetector(40*time.Millisecond))),
+		"dynamic": full(New(context.Background(), 1, WithStallDetector(40*time.Millisecond))),
+	}
+	for name, h := range pools {
+		// quick jobs keep freeing the thread
+		for i := 0; i < 8; i++ {
+			h.Add(func() { time.Sleep(10 * time.Millisecond) })
+		}
+		h.Barrier()()
+		if s := logged.String(); s != "" {
+			t.Fatalf("%v: expected no warning but found %q", name, s)
+		}
+
+		// a stuck job is warned about once
+		block := make(chan bool)
+		h.Add(func() { <-block })
+		time.Sleep(200 * time.Millisecond)
+		if n := strings.Count(logged.String(), "no thread freed"); n != 1 {
+			t.Fatalf("%v: expected %v but found %v in %q", name, 1, n, logged.String())
+		}
+		close(block)
+		h.ForceFinish()
+		h.Wait()
+
+		logged.mux.Lock()
+		logged.buf.Reset()
+		logged.mux.Unlock()
+	}
+}
+
+func TestPool_AddAfter(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 4)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		start := time.Now()
+		ran := make(chan time.Duration, 2)
+		h.AddAfter(50*time.Millisecond, func() { ran <- time.Since(start) })
+		h.AddAfter(0, func() { ran <- time.Since(start) })
+		h.Barrier()()
+		if first, second := <-ran, <-ran; first >= 50*time.Millisecond || second < 50*time.Millisecond {
+			t.Fatalf("%v: expected the delayed job after %v but found %v and %v", name, 50*time.Millisecond, first, second)
+		}
+
+		// finishing the pool drops a job still waiting out its delay
+		h.AddAfter(time.Hour, func() { t.Errorf("%v: expected the job to be dropped", name) })
+		h.ForceFinish()
+		if !h.WaitTimeout(time.Second) {
+			t.Fatalf("%v: expected Wait to return after ForceFinish", name)
+		}
+	}
+}
+
+func TestPool_AddRetry(t *testing.T) {
+	flaky := errors.New("flaky")
+	h := full(New(context.Background(), 2, WithCancelOnError()))
+
+	var attempts []int
+	var runs int32
+	h.AddRetry(func() error {
+		if atomic.AddInt32(&runs, 1) < 3 {
+			return flaky
+		}
+		return nil
+	}, 5, func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	})
+	h.Barrier()()
+	if runs != 3 || len(attempts) != 2 || attempts[1] != 2 {
+		t.Fatalf("expected %v runs but found %v with backoffs after %v", 3, runs, attempts)
+	}
+	if errs := h.Errors(); len(errs) != 0 {
+		t.Fatalf("expected no errors but found %v", errs)
+	}
+
+	// the last error is kept and finishes the pool, ForceFinish cutting short the backoff
+	runs = 0
+	h.AddRetry(func() error {
+		atomic.AddInt32(&runs, 1)
+		return flaky
+	}, 3, func(int) time.Duration { return time.Millisecond })
+	if err := h.WaitErr(); err != flaky {
+		t.Fatalf("expected %v but found %v", flaky, err)
+	}
+	if runs != 3 {
+		t.Fatalf("expected %v but found %v", 3, runs)
+	}
+
+	h = full(New(context.Background(), 1))
+	started := make(chan bool, 1)
+	h.AddRetry(func() error {
+		select {
+		case started <- true:
+		default:
+		}
+		return flaky
+	}, 2, func(int) time.Duration { return time.Hour })
+	<-started
+	h.ForceFinish()
+	if !h.WaitTimeout(time.Second) {
+		t.Fatalf("expected ForceFinish to cut the backoff short")
+	}
+}
+
+func TestPool_Close(t *testing.T) {
+	before := runtime.NumGoroutine()
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 10, WithRampUp(time.Hour), WithStallDetector(time.Second))),
+		"dynamic": full(New(context.Background(), 2, WithRampUp(time.Hour), WithStallDetector(time.Second))),
+	}
+	for name, h := range pools {
+		var runs int32
+		for i := 0; i < 4; i++ {
+			h.AddNoWait(func() {
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&runs, 1)
+			})
+		}
+		if err := h.Close(); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+		if runs != 4 {
+			t.Fatalf("%v: expected %v but found %v", name, 4, runs)
+		}
+		if err := h.Err(); err != ErrPoolClosed {
+			t.Fatalf("%v: expected %v but found %v", name, ErrPoolClosed, err)
+		}
+		if err := h.AddOrErr(func() {}); err != ErrPoolClosed {
+			t.Fatalf("%v: expected %v but found %v", name, ErrPoolClosed, err)
+		}
+		if err := h.Close(); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+	}
+
+	// the ramp up and stall detector goroutines are gone
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expected at most %v goroutines but found %v", before, n)
+	}
+}
+
+func TestPool_AcquireRelease(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 2, 1)),
+		"dynamic": full(New(context.Background(), 2)),
+	}
+	for name, h := range pools {
+		for i := 0; i < 2; i++ {
+			if err := h.Acquire(context.Background()); err != nil {
+				t.Fatalf("%v: expected no error but found %v", name, err)
+			}
+		}
+		if s := h.Stats(); s.Available != 0 {
+			t.Fatalf("%v: expected %v but found %v", name, 0, s.Available)
+		}
+
+		// with every thread taken Acquire waits, and jobs do too
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if err := h.Acquire(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("%v: expected %v but found %v", name, context.DeadlineExceeded, err)
+		}
+		cancel()
+		if h.TryAdd(func() {}) {
+			t.Fatalf("%v: expected TryAdd to fail with every thread taken", name)
+		}
+
+		h.Release()
+		h.Release()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%v: expected Release without Acquire to panic", name)
+				}
+			}()
+			h.Release()
+		}()
+
+		var ran int32
+		h.Add(func() { atomic.AddInt32(&ran, 1) })
+		h.Barrier()()
+		if ran != 1 {
+			t.Fatalf("%v: expected %v but found %v", name, 1, ran)
+		}
+
+		h.ForceFinish()
+		if err := h.Acquire(context.Background()); err != ErrPoolClosed {
+			t.Fatalf("%v: expected %v but found %v", name, ErrPoolClosed, err)
+		}
+	}
+}
+
+func TestPool_ConcurrentWait(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		pools := map[string]pool{
+			"fixed":   full(NewFixedSize(context.Background(), 2, 50)),
+			"dynamic": full(New(context.Background(), 2)),
+		}
+		for name, h := range pools {
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					h.Wait()
+				}()
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						h.AddNoWait(func() { time.Sleep(100 * time.Microsecond) })
+					}
+				}()
+			}
+			time.Sleep(time.Duration(round%3) * time.Millisecond)
+			h.ForceFinish()
+
+			done := make(chan bool)
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("%v round %v: expected every Wait to return", name, round)
+			}
+		}
+	}
+}
+
+func TestPool_WaitCtx(t *testing.T) {
+	pools := map[string]pool{
+		"fixed":   full(NewFixedSize(context.Background(), 1, 1)),
+		"dynamic": full(New(context.Background(), 1)),
+	}
+	for name, h := range pools {
+		block := make(chan bool)
+		h.AddNoWait(func() { <-block })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if err := h.WaitCtx(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("%v: expected %v but found %v", name, context.DeadlineExceeded, err)
+		}
+		cancel()
+		if h.IsDone() {
+			t.Fatalf("%v: expected the pool to carry on", name)
+		}
+
+		close(block)
+		if err := h.WaitCtx(context.Background()); err != nil {
+			t.Fatalf("%v: expected no error but found %v", name, err)
+		}
+	}
+}
+
+func TestPool_String(t *testing.T) {
+	h := full(NewFixedSize(context.Background(), 2, 5, WithName("ingest")))
+	block := make(chan bool)
+	h.Add(func() { <-block })
+	expected := "threadpool[name=ingest kind=Fixed concurrency=2 running=1 waiting=0 pending=4]"
+	if s := h.String(); s != expected {
+		t.Fatalf("expected %v but found %v", expected, s)
+	}
+	close(block)
+	h.ForceFinish()
+
+	d := full(New(context.Background(), 3))
+	expected = "threadpool[kind=Dynamic concurrency=3 running=0 waiting=0]"
+	if s := d.String(); s != expected {
+		t.Fatalf("expected %v but found %v", expected, s)
+	}
+	if name := full(NewFixedSize(context.Background(), 1, 1, WithName("x"))).Config().Name; name != "x" {
+		t.Fatalf("expected %v but found %v", "x", name)
+	}
+}
+
+func TestPool_AddAfterWait(t *testing.T) {
+	h := New(context.Background(), 4)
+
+	// a long lived pool fed in rounds with Wait at each checkpoint, while other goroutines
+	// wait on it too
+	stop := make(chan bool)
+	var waiters sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		waiters.Add(1)
+		go func() {
+			defer waiters.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.Wait()
+				}
+			}
+		}()
+	}
+
+	var runs int32
+	for round := 1; round <= 20; round++ {
+		for i := 0; i < 10; i++ {
+			h.Add(func() { atomic.AddInt32(&runs, 1) })
+		}
+		h.Wait()
+		if r := atomic.LoadInt32(&runs); r != int32(round*10) {
+			t.Fatalf("round %v: expected %v but found %v", round, round*10, r)
+		}
+	}
+	close(stop)
+	waiters.Wait()
+}
+
+func TestPool_NewWithOptions(t *testing.T) {
+	h := full(NewWithOptions(context.Background(), WithConcurrency(3), WithName("opts")))
+	if c := h.Config(); c.Kind != Dynamic || c.ConcurrentThreads != 3 || c.TotalJobs != -1 || c.Name != "opts" {
+		t.Fatalf("expected a dynamic pool of %v threads but found %+v", 3, c)
+	}
+
+	f := full(NewFixedSizeWithOptions(context.Background(), WithTotal(5)))
+	if c := f.Config(); c.Kind != Fixed || c.ConcurrentThreads != runtime.NumCPU() || c.TotalJobs != 5 {
+		t.Fatalf("expected a fixed pool of %v jobs but found %+v", 5, c)
+	}
+	var runs int32
+	for i := 0; i < 5; i++ {
+		f.Add(func() { atomic.AddInt32(&runs, 1) })
+	}
+	f.Wait()
+	if runs != 5 {
+		t.Fatalf("expected %v but found %v", 5, runs)
+	}
+
+	// the arguments of the older constructors win over the options
+	opts := []Option{WithConcurrency(7), WithTotal(9)}
+	if c := full(NewFixedSize(context.Background(), 2, 4, opts...)).Config(); c.ConcurrentThreads != 2 || c.TotalJobs != 4 {
+		t.Fatalf("expected %v threads and %v jobs but found %+v", 2, 4, c)
+	}
+	if c := full(New(context.Background(), 2, opts...)).Config(); c.ConcurrentThreads != 2 || c.TotalJobs != -1 {
+		t.Fatalf("expected %v threads but found %+v", 2, c)
+	}
+}
+
+// full gives a test every method of a pool from New() or NewFixedSize().
+func full(p Pool) pool {
+	return p.(pool)
+}
@@ -0,0 +1,122 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Tiered is a thread pool with two classes of jobs sharing a limit on threads. Urgent jobs
+// have threads reserved for them that bulk jobs never use, so they can start promptly even
+// when bulk jobs keep every other thread busy.
+type Tiered struct {
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	urgent    chan bool
+	bulk      chan bool
+	panics    panicHandler
+	wg        sync.WaitGroup
+}
+
+var _ PanicRecoverer = (*Tiered)(nil)
+
+// NewTiered creates a tiered thread pool running at most total jobs at once, with
+// urgentReserved of those threads only used by AddUrgent().
+//
+// If total is <=0 it will assume runtime.NumCPU(). urgentReserved is limited to between 0
+// and total-1, so bulk jobs always have a thread. A nil ctx is treated as
+// context.Background().
+func NewTiered(ctx context.Context, urgentReserved, total int) *Tiered {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if total <= 0 {
+		total = runtime.NumCPU()
+	}
+	if urgentReserved < 0 {
+		urgentReserved = 0
+	}
+	if urgentReserved > total-1 {
+		urgentReserved = total - 1
+	}
+
+	cCtx, can := context.WithCancel(ctx)
+	p := &Tiered{
+		ctx:       cCtx,
+		ctxCancel: can,
+		urgent:    make(chan bool, urgentReserved),
+		bulk:      make(chan bool, total-urgentReserved),
+	}
+	for i := 0; i < cap(p.urgent); i++ {
+		p.urgent <- true
+	}
+	for i := 0; i < cap(p.bulk); i++ {
+		p.bulk <- true
+	}
+	return p
+}
+
+// AddUrgent adds a new urgent job to be ran. When called it will block until either a
+// reserved or a shared thread is free.
+func (p *Tiered) AddUrgent(f func()) {
+	var c chan bool
+	select {
+	case <-p.urgent:
+		c = p.urgent
+	case <-p.bulk:
+		c = p.bulk
+	case <-p.ctx.Done():
+		return
+	}
+	p.run(c, f)
+}
+
+// AddBulk adds a new bulk job to be ran. When called it will block until a shared thread is
+// free, the reserved threads are never used.
+func (p *Tiered) AddBulk(f func()) {
+	select {
+	case <-p.bulk:
+	case <-p.ctx.Done():
+		return
+	}
+	p.run(p.bulk, f)
+}
+
+// run runs f on a new goroutine holding a thread from c, unless the context was done while
+// waiting for the thread. A panic from f is recovered like it is by the pools from New().
+func (p *Tiered) run(c chan bool, f func()) {
+	if p.ctx.Err() != nil {
+		c <- true
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { c <- true }()
+		p.panics.guard(p.ctx, f)()
+	}()
+}
+
+// SetPanicHandler sets the handler given any panic recovered from a job, along with the
+// pool's context. A nil handler, the default, logs panics with the log package.
+func (p *Tiered) SetPanicHandler(handler func(ctx context.Context, recovered any, stack []byte)) {
+	p.panics.store(handler)
+}
+
+// PanickedJobs returns the jobs whose panic was recovered since the last call. The pool keeps
+// them until then, so call it regularly if many jobs may panic.
+func (p *Tiered) PanickedJobs() []func() {
+	return p.panics.take()
+}
+
+// ForceFinish provides an easy method to prevent any future AddUrgent() or AddBulk() from
+// running. Jobs already running are not stopped.
+func (p *Tiered) ForceFinish() {
+	p.ctxCancel()
+}
+
+// Wait when called will block until all running jobs are completed.
+func (p *Tiered) Wait() {
+	p.wg.Wait()
+}
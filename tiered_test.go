@@ -0,0 +1,104 @@
+package threadpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTiered(t *testing.T) {
+	reserved := 1
+	total := 3
+	p := NewTiered(context.Background(), reserved, total)
+
+	var running, peak int32
+	track := func(d time.Duration) func() {
+		return func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+			time.Sleep(d)
+			atomic.AddInt32(&running, -1)
+		}
+	}
+
+	// flood the shared threads with bulk jobs
+	flooded := make(chan bool)
+	go func() {
+		defer close(flooded)
+		for i := 0; i < 40; i++ {
+			p.AddBulk(track(50 * time.Millisecond))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	started := make(chan time.Time, 1)
+	added := time.Now()
+	p.AddUrgent(func() {
+		started <- time.Now()
+		track(time.Millisecond)()
+	})
+	if wait := (<-started).Sub(added); wait > 20*time.Millisecond {
+		t.Fatalf("expected the urgent job to start promptly but it waited %v", wait)
+	}
+
+	p.ForceFinish()
+	<-flooded
+	p.Wait()
+	found := atomic.LoadInt32(&peak)
+	if found > int32(total) {
+		t.Fatalf("expected at most %v running but found %v", total, found)
+	}
+	if bulk := int32(total - reserved); found < bulk {
+		t.Fatalf("expected the bulk jobs to fill %v threads but found %v", bulk, found)
+	}
+}
+
+func TestTiered_Panic(t *testing.T) {
+	p := NewTiered(context.Background(), 1, 2)
+	var recovered int32
+	p.SetPanicHandler(func(ctx context.Context, r any, stack []byte) {
+		atomic.AddInt32(&recovered, 1)
+	})
+
+	p.AddUrgent(func() { panic("urgent") })
+	p.AddBulk(func() { panic("bulk") })
+	p.Wait()
+	if recovered != 2 {
+		t.Fatalf("expected %v but found %v", 2, recovered)
+	}
+	if n := len(p.PanickedJobs()); n != 2 {
+		t.Fatalf("expected %v but found %v", 2, n)
+	}
+
+	// the threads were given back
+	var runs int32
+	for i := 0; i < 4; i++ {
+		p.AddBulk(func() { atomic.AddInt32(&runs, 1) })
+	}
+	p.Wait()
+	if runs != 4 {
+		t.Fatalf("expected %v but found %v", 4, runs)
+	}
+}
+
+func TestTiered_AllReserved(t *testing.T) {
+	p := NewTiered(context.Background(), 3, 3)
+
+	done := make(chan bool)
+	go func() {
+		p.AddBulk(func() {})
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a bulk job to run with every thread asked to be reserved")
+	}
+}
@@ -0,0 +1,189 @@
+package threadpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// WFQ is a thread pool that shares its threads between tenants using weighted fair queuing.
+// Over time each tenant with queued jobs gets a share of the execution time proportional to
+// its weight. The share of a tenant with nothing queued is split between the others.
+type WFQ struct {
+	mux       sync.Mutex
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	free      int
+	tenants   map[string]*tenant
+	panics    panicHandler
+	wg        sync.WaitGroup
+}
+
+var _ PanicRecoverer = (*WFQ)(nil)
+
+type tenant struct {
+	name     string
+	weight   int
+	used     time.Duration // execution time of completed jobs
+	running  int
+	startSum int64 // sum of the start times of running jobs in unix nanoseconds
+	queue    []func()
+}
+
+// virtual returns the tenant's execution time up to now divided by its weight.
+func (t *tenant) virtual(now int64) float64 {
+	used := int64(t.used) + int64(t.running)*now - t.startSum
+	return float64(used) / float64(t.weight)
+}
+
+// NewWFQ creates a weighted fair queuing thread pool with concurrentThreads limiter.
+//
+// If concurrentThreads is <=0 it will assume runtime.NumCPU(). A nil ctx is treated as
+// context.Background().
+func NewWFQ(ctx context.Context, concurrentThreads int) *WFQ {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if concurrentThreads <= 0 {
+		concurrentThreads = runtime.NumCPU()
+	}
+
+	cCtx, can := context.WithCancel(ctx)
+	return &WFQ{
+		ctx:       cCtx,
+		ctxCancel: can,
+		free:      concurrentThreads,
+		tenants:   make(map[string]*tenant),
+	}
+}
+
+// AddTenant queues a new job for tenant. It does not block, the job is ran once a thread is
+// free and tenant is the furthest below its share of the execution time.
+//
+//	weight is the tenant's share relative to the other tenants and replaces any weight
+//	given before. If weight is <=0 it will assume 1. A tenant that had nothing queued or
+//	running starts level with the busiest tenants, it gets no credit for being idle.
+func (p *WFQ) AddTenant(tenant string, weight int, f func()) {
+	if weight <= 0 {
+		weight = 1
+	}
+
+	p.wg.Add(1)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	t, ok := p.tenants[tenant]
+	if !ok {
+		t = p.newTenant(tenant, weight)
+		p.tenants[tenant] = t
+	}
+	t.weight = weight
+	t.queue = append(t.queue, f)
+	p.dispatch()
+}
+
+// newTenant returns a tenant whose virtual time matches the lowest of the active tenants.
+func (p *WFQ) newTenant(name string, weight int) *tenant {
+	t := &tenant{name: name, weight: weight}
+
+	now := time.Now().UnixNano()
+	first := true
+	var lowest float64
+	for _, other := range p.tenants {
+		if v := other.virtual(now); first || v < lowest {
+			lowest = v
+			first = false
+		}
+	}
+	if !first {
+		t.used = time.Duration(lowest * float64(weight))
+	}
+	return t
+}
+
+// dispatch starts queued jobs while there are free threads. It expects p.mux to be held.
+func (p *WFQ) dispatch() {
+	if p.ctx.Err() != nil {
+		for name, t := range p.tenants {
+			for range t.queue {
+				p.wg.Done()
+			}
+			t.queue = nil
+			if t.running == 0 {
+				delete(p.tenants, name)
+			}
+		}
+		return
+	}
+
+	for p.free > 0 {
+		now := time.Now()
+		var next *tenant
+		for _, t := range p.tenants {
+			if len(t.queue) == 0 {
+				continue
+			}
+			if next == nil || t.virtual(now.UnixNano()) < next.virtual(now.UnixNano()) {
+				next = t
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		f := next.queue[0]
+		next.queue[0] = nil
+		next.queue = next.queue[1:]
+		next.running++
+		next.startSum += now.UnixNano()
+		p.free--
+		go p.run(next, f, now)
+	}
+}
+
+// run runs f and frees its thread for the next job. A panic from f is recovered like it is by
+// the pools from New(), and the time until the panic is counted against the tenant.
+func (p *WFQ) run(t *tenant, f func(), start time.Time) {
+	p.panics.guard(p.ctx, f)()
+
+	p.mux.Lock()
+	t.running--
+	t.startSum -= start.UnixNano()
+	t.used += time.Since(start)
+	if t.running == 0 && len(t.queue) == 0 {
+		delete(p.tenants, t.name)
+	}
+	p.free++
+	p.dispatch()
+	p.mux.Unlock()
+
+	p.wg.Done()
+}
+
+// ForceFinish provides an easy method to drop all queued jobs and prevent any future
+// AddTenant() from running. Jobs already running are not stopped.
+func (p *WFQ) ForceFinish() {
+	p.ctxCancel()
+
+	p.mux.Lock()
+	p.dispatch()
+	p.mux.Unlock()
+}
+
+// Wait when called will block until all queued and running jobs are completed.
+func (p *WFQ) Wait() {
+	p.wg.Wait()
+}
+
+// SetPanicHandler sets the handler given any panic recovered from a job, along with the
+// pool's context. A nil handler, the default, logs panics with the log package.
+func (p *WFQ) SetPanicHandler(handler func(ctx context.Context, recovered any, stack []byte)) {
+	p.panics.store(handler)
+}
+
+// PanickedJobs returns the jobs whose panic was recovered since the last call. The pool keeps
+// them until then, so call it regularly if many jobs may panic.
+func (p *WFQ) PanickedJobs() []func() {
+	return p.panics.take()
+}
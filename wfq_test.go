@@ -0,0 +1,96 @@
+package threadpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWFQ_AddTenant(t *testing.T) {
+	total := 300
+	h := NewWFQ(context.Background(), 2)
+
+	mux := sync.Mutex{}
+	var order []string
+	job := func(tenant string) func() {
+		return func() {
+			time.Sleep(time.Millisecond)
+			mux.Lock()
+			order = append(order, tenant)
+			mux.Unlock()
+		}
+	}
+
+	for i := 0; i < total; i++ {
+		h.AddTenant("light", 1, job("light"))
+		h.AddTenant("heavy", 3, job("heavy"))
+	}
+	h.Wait()
+
+	// count only while both tenants had jobs queued
+	light, heavy := 0, 0
+	for _, tenant := range order {
+		if tenant == "light" {
+			light++
+		} else {
+			heavy++
+		}
+		if heavy == total {
+			break
+		}
+	}
+
+	ratio := float64(light) / float64(heavy)
+	if ratio < 0.25 || ratio > 0.45 {
+		t.Fatalf("expected a ratio of about %v but found %v (%v:%v)", 1.0/3, ratio, light, heavy)
+	}
+}
+
+func TestWFQ_ForceFinish(t *testing.T) {
+	h := NewWFQ(context.Background(), 1)
+
+	gate := make(chan bool)
+	ran := 0
+	h.AddTenant("a", 1, func() { <-gate })
+	for i := 0; i < 10; i++ {
+		h.AddTenant("b", 1, func() { ran++ })
+	}
+	h.ForceFinish()
+	close(gate)
+	h.Wait()
+
+	if ran != 0 {
+		t.Fatalf("expected %v but found %v", 0, ran)
+	}
+}
+
+func TestWFQ_NilContext(t *testing.T) {
+	var ctx context.Context
+	h := NewWFQ(ctx, 1)
+
+	ran := false
+	h.AddTenant("a", 1, func() { ran = true })
+	h.Wait()
+
+	if !ran {
+		t.Fatalf("expected the job to run")
+	}
+}
+
+func TestWFQ_Panic(t *testing.T) {
+	h := NewWFQ(context.Background(), 1)
+	h.SetPanicHandler(func(ctx context.Context, recovered any, stack []byte) {})
+
+	ran := false
+	h.AddTenant("a", 1, func() { panic("boom") })
+	h.AddTenant("b", 1, func() { ran = true })
+	h.Wait()
+
+	if !ran {
+		t.Fatalf("expected the job after the panic to run")
+	}
+	if n := len(h.PanickedJobs()); n != 1 {
+		t.Fatalf("expected %v but found %v", 1, n)
+	}
+}